@@ -0,0 +1,328 @@
+package cdclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DropPolicy decides what an AsyncClient does when its queue is full.
+type DropPolicy byte
+
+const (
+	// DropNewest discards the value list being added.
+	DropNewest DropPolicy = iota
+	// DropOldest discards the oldest queued value list.
+	DropOldest
+	// Block waits for space in the queue.
+	Block
+)
+
+// DefaultQueueSize is the default number of value lists an AsyncClient queues.
+const DefaultQueueSize = 1024
+
+type AsyncClientOptions struct {
+	// QueueSize is the number of value lists that can be queued.
+	// When zero, DefaultQueueSize is used.
+	QueueSize int
+	// Policy determines what happens when the queue is full.
+	Policy DropPolicy
+	// FlushInterval is how often partially full packets are sent.
+	// When zero packets are only sent once full or on Flush.
+	FlushInterval time.Duration
+	// Defaults fill in the Host and Interval of metrics without them.
+	Defaults MetricDefaults
+}
+
+var ErrClientClosed = errors.New("client closed")
+
+type asyncEntry struct {
+	metric *Metric
+	time   time.Time
+	values []float64
+}
+
+// AsyncClient queues value lists in a bounded, preallocated ring buffer and
+// encodes and sends them from a background goroutine, so adding values never
+// performs network writes.
+// The client is safe to use from multiple goroutines concurrently.
+type AsyncClient struct {
+	lock     sync.Mutex
+	notEmpty sync.Cond
+	notFull  sync.Cond
+	flushed  sync.Cond
+	policy   DropPolicy
+	queue    []asyncEntry
+	head     int
+	n        int
+	closed   bool
+	stats    ClientStats
+	// enqueued and consumed count value lists added to and
+	// taken from the queue, including those dropped from it.
+	enqueued uint64
+	consumed uint64
+	// Flush requests are numbered, flushDone is the last completed.
+	// flushAt holds, for each pending request, the value of enqueued
+	// when it was made.
+	flushWant uint64
+	flushDone uint64
+	flushAt   []uint64
+	flushErr  error
+	sendErr   error
+
+	// Owned by the background goroutine, sendStats
+	// is merged into stats while holding the lock.
+	sendStats ClientStats
+	packet    Packet
+	conn      Transport
+	values    []float64
+	defaults  MetricDefaults
+	tmpMetric Metric
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewAsyncClient creates a client that encodes value lists into p and
+// writes full packets to t from a background goroutine.
+func NewAsyncClient(p Packet, t Transport, opts AsyncClientOptions) *AsyncClient {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	c := &AsyncClient{
+		policy:   opts.Policy,
+		queue:    make([]asyncEntry, opts.QueueSize),
+		packet:   p,
+		conn:     t,
+		defaults: opts.Defaults,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	c.notEmpty.L = &c.lock
+	c.notFull.L = &c.lock
+	c.flushed.L = &c.lock
+	// Preallocate room for a few values per entry, entries
+	// with more values grow once and keep their storage.
+	const prealloc = 4
+	values := make([]float64, opts.QueueSize*prealloc)
+	for i := range c.queue {
+		c.queue[i].values = values[i*prealloc : i*prealloc : (i+1)*prealloc]
+	}
+	go c.run()
+	if opts.FlushInterval > 0 {
+		go c.tick(opts.FlushInterval)
+	}
+	return c
+}
+
+func (c *AsyncClient) AddValues(m *Metric, t time.Time, values ...float64) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, err := c.reserve()
+	if e == nil {
+		return err
+	}
+	e.metric = m
+	e.time = t
+	e.values = append(e.values[:0], values...)
+	c.notEmpty.Signal()
+	return nil
+}
+
+func (c *AsyncClient) AddValueList(v ValueList) error {
+	return c.AddValues(v.Metric, v.Time, v.Values...)
+}
+
+// reserve returns the queue entry to fill, or nil if the value
+// list should be dropped.
+func (c *AsyncClient) reserve() (*asyncEntry, error) {
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	if c.n == len(c.queue) {
+		switch c.policy {
+		case DropOldest:
+			c.head = (c.head + 1) % len(c.queue)
+			c.n--
+			c.consumed++
+			c.stats.ValueListsDropped++
+		case Block:
+			for c.n == len(c.queue) && !c.closed {
+				c.notFull.Wait()
+			}
+			if c.closed {
+				return nil, ErrClientClosed
+			}
+		default:
+			c.stats.ValueListsDropped++
+			return nil, nil
+		}
+	}
+	e := &c.queue[(c.head+c.n)%len(c.queue)]
+	c.n++
+	c.enqueued++
+	return e, nil
+}
+
+// Stats returns a snapshot of the client statistics.
+func (c *AsyncClient) Stats() ClientStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.stats
+}
+
+// Flush waits until every value list queued before the call has been sent,
+// it returns the first send error since the previous flush.
+func (c *AsyncClient) Flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return ErrClientClosed
+	}
+	want := c.requestFlush()
+	for c.flushDone < want {
+		c.flushed.Wait()
+	}
+	return c.flushErr
+}
+
+// Close sends any queued value lists, stops the background
+// goroutine and closes the transport.
+func (c *AsyncClient) Close() error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return ErrClientClosed
+	}
+	c.closed = true
+	close(c.stop)
+	c.notEmpty.Signal()
+	c.notFull.Broadcast()
+	c.lock.Unlock()
+	<-c.done
+	err := c.flushErr
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (c *AsyncClient) tick(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.lock.Lock()
+			if c.flushDone == c.flushWant {
+				c.requestFlush()
+			}
+			c.lock.Unlock()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// requestFlush asks the background goroutine to send everything queued so
+// far, value lists queued later do not delay the flush. It returns the
+// request number to wait for.
+func (c *AsyncClient) requestFlush() uint64 {
+	c.flushWant++
+	c.flushAt = append(c.flushAt, c.enqueued)
+	c.notEmpty.Signal()
+	return c.flushWant
+}
+
+// flushDue reports whether the oldest pending flush
+// request can be completed.
+func (c *AsyncClient) flushDue() bool {
+	return len(c.flushAt) != 0 && c.consumed >= c.flushAt[0]
+}
+
+func (c *AsyncClient) run() {
+	defer close(c.done)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for {
+		for c.n == 0 && !c.closed && !c.flushDue() {
+			c.notEmpty.Wait()
+		}
+		if c.n != 0 && !c.flushDue() {
+			e := &c.queue[c.head]
+			v := ValueList{
+				Metric: e.metric,
+				Time:   e.time,
+			}
+			c.values = append(c.values[:0], e.values...)
+			v.Values = c.values
+			c.head = (c.head + 1) % len(c.queue)
+			c.n--
+			c.consumed++
+			c.notFull.Signal()
+			c.lock.Unlock()
+			v.Metric = c.defaults.apply(v.Metric, &c.tmpMetric)
+			err := c.add(v)
+			c.lock.Lock()
+			c.mergeStats()
+			if err != nil && c.sendErr == nil {
+				c.sendErr = err
+			}
+			continue
+		}
+		// Complete every request whose value lists have been consumed.
+		done := 0
+		for done < len(c.flushAt) && c.consumed >= c.flushAt[done] {
+			done++
+		}
+		c.lock.Unlock()
+		err := c.send()
+		c.lock.Lock()
+		c.mergeStats()
+		if err == nil {
+			err = c.sendErr
+		}
+		c.sendErr = nil
+		c.flushErr = err
+		c.flushAt = append(c.flushAt[:0], c.flushAt[done:]...)
+		c.flushDone += uint64(done)
+		c.flushed.Broadcast()
+		if c.closed && c.n == 0 {
+			return
+		}
+	}
+}
+
+func (c *AsyncClient) mergeStats() {
+	c.stats.add(c.sendStats)
+	c.sendStats = ClientStats{}
+}
+
+func (c *AsyncClient) add(v ValueList) error {
+	err := c.packet.AddValueList(v)
+	if errors.Is(err, ErrPacketFull) {
+		c.sendStats.FullFlushes++
+		err = c.send()
+		if err != nil {
+			return err
+		}
+		return c.packet.AddValueList(v)
+	}
+	return err
+}
+
+func (c *AsyncClient) send() error {
+	buf, err := finalize(c.packet)
+	if err != nil {
+		c.sendStats.PacketsDropped++
+		c.packet.Reset()
+		return err
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err = c.conn.Write(buf)
+	c.sendStats.countWrite(len(buf), err)
+	c.packet.Reset()
+	return err
+}
@@ -0,0 +1,188 @@
+package cdclient
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// blockingTransport blocks writes until release is closed.
+type blockingTransport struct {
+	MemoryTransport
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (t *blockingTransport) Write(b []byte) (int, error) {
+	select {
+	case t.entered <- struct{}{}:
+	default:
+	}
+	<-t.release
+	return t.MemoryTransport.Write(b)
+}
+
+func TestAsyncClient(t *testing.T) {
+	tr := &MemoryTransport{}
+	c := NewAsyncClient(NewPlainTextPacketSize(MinimumBufferSize), tr, AsyncClientOptions{})
+	m := testMetric()
+	now := time.Unix(1426076671, 0)
+	for i := 0; i < 100; i++ {
+		if err := c.AddValues(m, now.Add(time.Duration(i)*time.Second), float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, p := range tr.Packets() {
+		total += countValueLists(p)
+	}
+	if total != 100 {
+		t.Fatalf("got %d value lists, want 100", total)
+	}
+	if err := c.AddValues(m, now, 1); err != ErrClientClosed {
+		t.Fatalf("got %v, want ErrClientClosed", err)
+	}
+}
+
+func TestAsyncClientDefaults(t *testing.T) {
+	tr := &MemoryTransport{}
+	c := NewAsyncClient(NewPlainTextPacket(), tr, AsyncClientOptions{
+		Defaults: MetricDefaults{Host: "example.com", Interval: 10 * time.Second},
+	})
+	m := testMetric()
+	now := time.Unix(1426076671, 123000000)
+	want := NewPlainTextPacket()
+	_ = want.AddValues(m, now, 1)
+
+	m.Host = ""
+	m.Interval = 0
+	if err := c.AddValues(m, now, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got := tr.Packets()
+	if len(got) != 1 || !bytes.Equal(got[0], want.Finalize()) {
+		t.Fatalf("got %v, want %v", got, want.Finalize())
+	}
+}
+
+func TestAsyncClientDropPolicy(t *testing.T) {
+	for _, policy := range []DropPolicy{DropNewest, DropOldest} {
+		tr := &blockingTransport{
+			entered: make(chan struct{}, 1),
+			release: make(chan struct{}),
+		}
+		c := NewAsyncClient(NewPlainTextPacket(), tr, AsyncClientOptions{
+			QueueSize: 4,
+			Policy:    policy,
+		})
+		m := testMetric()
+		now := time.Unix(1426076671, 0)
+
+		// Stall the background goroutine in a write.
+		_ = c.AddValues(m, now, 0)
+		go c.Flush()
+		<-tr.entered
+
+		for i := 1; i <= 7; i++ {
+			if err := c.AddValues(m, now.Add(time.Duration(i)*time.Second), float64(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if d := c.Stats().ValueListsDropped; d != 3 {
+			t.Errorf("policy %d dropped %d, want 3", policy, d)
+		}
+		close(tr.release)
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		total := 0
+		for _, p := range tr.Packets() {
+			total += countValueLists(p)
+		}
+		if total != 5 {
+			t.Errorf("policy %d sent %d value lists, want 5", policy, total)
+		}
+	}
+}
+
+func TestAsyncClientFlushUnderLoad(t *testing.T) {
+	tr := &MemoryTransport{}
+	c := NewAsyncClient(NewPlainTextPacket(), tr, AsyncClientOptions{
+		QueueSize: 4,
+		Policy:    Block,
+	})
+	m := testMetric()
+	now := time.Unix(1426076671, 0)
+	if err := c.AddValues(m, now, 1); err != nil {
+		t.Fatal(err)
+	}
+	added := 1
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if c.AddValues(m, now.Add(time.Duration(i)*time.Second), float64(i)) == nil {
+				added++
+			}
+		}
+	}()
+	// The queue never stays empty, but the flush only
+	// waits for the value lists queued before it.
+	for i := 0; i < 10; i++ {
+		flushed := make(chan error, 1)
+		go func() { flushed <- c.Flush() }()
+		select {
+		case err := <-flushed:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("flush did not complete while values were being added")
+		}
+	}
+	close(stop)
+	<-stopped
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, p := range tr.Packets() {
+		total += countValueLists(p)
+	}
+	if total != added {
+		t.Fatalf("sent %d value lists, want %d", total, added)
+	}
+}
+
+func BenchmarkAsyncClient(bench *testing.B) {
+	c := NewAsyncClient(NewPlainTextPacket(), discardTransport{}, AsyncClientOptions{})
+	defer c.Close()
+	v := ValueList{
+		Metric: &Metric{
+			Host:     "example.com",
+			Plugin:   "golang",
+			Type:     "foobar",
+			DSTypes:  []DSType{DERIVE, GAUGE},
+			Interval: 10 * time.Second,
+		},
+		Time:   time.Unix(1426076671, 123000000),
+		Values: []float64{1, 2},
+	}
+	bench.ReportAllocs()
+	bench.ResetTimer()
+	for n := 0; n < bench.N; n++ {
+		_ = c.AddValueList(v)
+	}
+}
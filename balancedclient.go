@@ -0,0 +1,316 @@
+package cdclient
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type BalanceMode byte
+
+const (
+	// BalanceRoundRobin sends each packet to the next server in turn.
+	BalanceRoundRobin BalanceMode = iota
+	// BalanceByMetric always sends metrics with the same host and
+	// plugin to the same server, so a series lands on one aggregator.
+	BalanceByMetric
+)
+
+// BalancedClientOptions configures a BalancedClient. The socket options
+// of UDPClientOptions only apply to the sockets dialed by DialBalancedUDP,
+// and PathMTU and ResolveInterval are not supported.
+type BalancedClientOptions struct {
+	UDPClientOptions
+	// Mode determines how packets are distributed over the servers.
+	Mode BalanceMode
+	// MaxErrors is the number of consecutive write errors after which
+	// a server is removed. When zero servers are never removed.
+	MaxErrors int
+}
+
+// ServerStats are the statistics of a single server of a BalancedClient.
+type ServerStats struct {
+	Address     string
+	PacketsSent uint64
+	BytesSent   uint64
+	WriteErrors uint64
+	// Removed is set once the server has been removed for
+	// reporting too many consecutive errors.
+	Removed bool
+}
+
+var ErrNoServers = errors.New("no servers available")
+
+// BalancedClient distributes metrics over several collectd servers.
+// The client is safe to use from multiple goroutines concurrently.
+type BalancedClient struct {
+	mode    BalanceMode
+	servers []*balancedServer
+	lock    sync.RWMutex
+	live    []*balancedServer
+	next    uint32
+	// rr is the single client used in round robin mode.
+	rr        *UDPClient
+	statsStop chan struct{}
+	statsOnce sync.Once
+}
+
+// balancedServer is the Transport of a single server, it counts
+// writes and removes the server after too many errors.
+type balancedServer struct {
+	conn      Transport
+	seed      uint32
+	maxErrors int
+	balancer  *BalancedClient
+	// client is the server's own client in metric mode.
+	client *UDPClient
+
+	lock   sync.Mutex
+	stats  ServerStats
+	errors int
+}
+
+// DialBalancedUDP connects to every collectd server in addresses.
+func DialBalancedUDP(addresses []string, opts BalancedClientOptions) (*BalancedClient, error) {
+	conns := make([]Transport, 0, len(addresses))
+	for _, address := range addresses {
+		conn, err := dialTransport("udp", address, &opts.UDPClientOptions)
+		if err != nil {
+			for _, conn := range conns {
+				_ = conn.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	c, err := newBalancedClient(addresses, conns, opts)
+	if err != nil {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func newBalancedClient(addresses []string, conns []Transport, opts BalancedClientOptions) (*BalancedClient, error) {
+	if len(conns) == 0 {
+		return nil, ErrNoServers
+	}
+	if opts.PathMTU || opts.ResolveInterval != 0 {
+		return nil, errors.New("PathMTU and ResolveInterval are not supported by balanced clients")
+	}
+	c := &BalancedClient{mode: opts.Mode}
+	for i, conn := range conns {
+		s := &balancedServer{
+			conn:      conn,
+			seed:      fnvString(fnvOffset, addresses[i]),
+			maxErrors: opts.MaxErrors,
+			balancer:  c,
+		}
+		s.stats.Address = addresses[i]
+		c.servers = append(c.servers, s)
+	}
+	c.live = append([]*balancedServer(nil), c.servers...)
+
+	// Stats are reported once for all servers.
+	clientOpts := opts.UDPClientOptions
+	clientOpts.StatsInterval = 0
+	var err error
+	switch opts.Mode {
+	case BalanceRoundRobin:
+		c.rr, err = NewUDPClient(roundRobinTransport{c}, clientOpts)
+		if err != nil {
+			return nil, err
+		}
+	case BalanceByMetric:
+		for _, s := range c.servers {
+			s.client, err = NewUDPClient(s, clientOpts)
+			if err != nil {
+				for _, s := range c.servers {
+					if s.client != nil {
+						_ = s.client.Close()
+					}
+				}
+				return nil, err
+			}
+		}
+	default:
+		return nil, errors.New("unsupported balance mode")
+	}
+	c.statsStop = startStats(c, &opts.UDPClientOptions)
+	return c, nil
+}
+
+func (s *balancedServer) Write(b []byte) (int, error) {
+	n, err := s.conn.Write(b)
+	remove := false
+	s.lock.Lock()
+	if err != nil {
+		s.stats.WriteErrors++
+		s.errors++
+		if s.maxErrors > 0 && s.errors >= s.maxErrors && !s.stats.Removed {
+			s.stats.Removed = true
+			remove = true
+		}
+	} else {
+		s.stats.PacketsSent++
+		s.stats.BytesSent += uint64(n)
+		s.errors = 0
+	}
+	s.lock.Unlock()
+	if remove {
+		s.balancer.remove(s)
+	}
+	return n, err
+}
+
+// Close does nothing, the connections are owned by the BalancedClient.
+func (s *balancedServer) Close() error {
+	return nil
+}
+
+type roundRobinTransport struct {
+	c *BalancedClient
+}
+
+func (t roundRobinTransport) Write(b []byte) (int, error) {
+	t.c.lock.RLock()
+	if len(t.c.live) == 0 {
+		t.c.lock.RUnlock()
+		return 0, ErrNoServers
+	}
+	s := t.c.live[atomic.AddUint32(&t.c.next, 1)%uint32(len(t.c.live))]
+	t.c.lock.RUnlock()
+	return s.Write(b)
+}
+
+func (t roundRobinTransport) Close() error {
+	return nil
+}
+
+func (c *BalancedClient) remove(s *balancedServer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, l := range c.live {
+		if l == s {
+			c.live = append(c.live[:i:i], c.live[i+1:]...)
+			return
+		}
+	}
+}
+
+// client returns the client for a metric, the balancer lock must not be
+// held while using it, as writes may remove servers.
+func (c *BalancedClient) client(m *Metric) (*UDPClient, error) {
+	if c.rr != nil {
+		return c.rr, nil
+	}
+	h := fnvString(fnvString(fnvOffset, m.Host), m.Plugin)
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	// Rendezvous hashing, only the series of a
+	// removed server move to a different server.
+	var best *balancedServer
+	var bestScore uint32
+	for _, s := range c.live {
+		score := mix32(h ^ s.seed)
+		if best == nil || score > bestScore {
+			best = s
+			bestScore = score
+		}
+	}
+	if best == nil {
+		return nil, ErrNoServers
+	}
+	return best.client, nil
+}
+
+// mix32 is the murmur3 finalizer.
+func mix32(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+func (c *BalancedClient) AddValues(m *Metric, t time.Time, values ...float64) error {
+	cl, err := c.client(m)
+	if err != nil {
+		return err
+	}
+	return cl.AddValues(m, t, values...)
+}
+
+func (c *BalancedClient) AddValueList(v ValueList) error {
+	cl, err := c.client(v.Metric)
+	if err != nil {
+		return err
+	}
+	return cl.AddValueList(v)
+}
+
+func (c *BalancedClient) clients() []*UDPClient {
+	if c.rr != nil {
+		return []*UDPClient{c.rr}
+	}
+	clients := make([]*UDPClient, 0, len(c.servers))
+	for _, s := range c.servers {
+		clients = append(clients, s.client)
+	}
+	return clients
+}
+
+// Flush flushes all buffered metrics, returning the first error.
+func (c *BalancedClient) Flush() error {
+	var err error
+	for _, cl := range c.clients() {
+		if ferr := cl.Flush(); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+// Stats returns the sum of the client statistics.
+func (c *BalancedClient) Stats() ClientStats {
+	var stats ClientStats
+	for _, cl := range c.clients() {
+		stats.add(cl.Stats())
+	}
+	return stats
+}
+
+// ServerStats returns the statistics of each server.
+func (c *BalancedClient) ServerStats() []ServerStats {
+	stats := make([]ServerStats, 0, len(c.servers))
+	for _, s := range c.servers {
+		s.lock.Lock()
+		stats = append(stats, s.stats)
+		s.lock.Unlock()
+	}
+	return stats
+}
+
+// Close closes the connections to all servers, returning the first error.
+func (c *BalancedClient) Close() error {
+	c.statsOnce.Do(func() {
+		if c.statsStop != nil {
+			close(c.statsStop)
+			c.statsStop = nil
+		}
+	})
+	for _, cl := range c.clients() {
+		_ = cl.Close()
+	}
+	var err error
+	for _, s := range c.servers {
+		if cerr := s.conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
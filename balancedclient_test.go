@@ -0,0 +1,115 @@
+package cdclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBalancedClientRoundRobin(t *testing.T) {
+	trs := []*MemoryTransport{{}, {}, {}}
+	c, err := newBalancedClient(
+		[]string{"a", "b", "c"},
+		[]Transport{trs[0], trs[1], trs[2]},
+		BalancedClientOptions{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		_ = c.AddValues(testMetric(), time.Now(), 1)
+		if err := c.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, tr := range trs {
+		if n := len(tr.Packets()); n != 2 {
+			t.Errorf("server %d got %d packets, want 2", i, n)
+		}
+	}
+}
+
+func TestBalancedClientByMetric(t *testing.T) {
+	trs := []*MemoryTransport{{}, {}, {}}
+	c, err := newBalancedClient(
+		[]string{"a", "b", "c"},
+		[]Transport{trs[0], failingTransport{}, trs[2]},
+		BalancedClientOptions{Mode: BalanceByMetric, MaxErrors: 1},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := []string{"h1", "h2", "h3", "h4", "h5", "h6", "h7", "h8"}
+	for round := 0; round < 2; round++ {
+		for _, h := range hosts {
+			m := testMetric()
+			m.Host = h
+			_ = c.AddValues(m, time.Now(), 1)
+		}
+		_ = c.Flush()
+	}
+
+	stats := c.ServerStats()
+	if stats[1].WriteErrors == 0 || !stats[1].Removed {
+		t.Fatalf("failing server not removed: %+v", stats[1])
+	}
+	// After removal every host lands on exactly one of the remaining servers.
+	_ = trs[0].Packets()
+	_ = trs[2].Packets()
+	for _, h := range hosts {
+		m := testMetric()
+		m.Host = h
+		_ = c.AddValues(m, time.Now(), 1)
+		if err := c.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(trs[0].Packets()) + len(trs[2].Packets()); n != 1 {
+			t.Fatalf("host %s sent %d packets, want 1", h, n)
+		}
+	}
+}
+
+func TestBalancedClientStats(t *testing.T) {
+	trs := []*MemoryTransport{{}, {}}
+	opts := BalancedClientOptions{Mode: BalanceByMetric}
+	opts.StatsInterval = 10 * time.Millisecond
+	opts.StatsHost = "example.com"
+	c, err := newBalancedClient([]string{"a", "b"}, []Transport{trs[0], trs[1]}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, s := range c.servers {
+		if s.client.statsStop != nil {
+			t.Fatal("server clients should not report their own stats")
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(trs[0].Packets())+len(trs[1].Packets()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("stats were not reported")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBalancedClientUnsupportedOptions(t *testing.T) {
+	for _, opts := range []BalancedClientOptions{
+		{UDPClientOptions: UDPClientOptions{PathMTU: true}},
+		{UDPClientOptions: UDPClientOptions{ResolveInterval: time.Second}},
+	} {
+		if _, err := newBalancedClient([]string{"a"}, []Transport{&MemoryTransport{}}, opts); err == nil {
+			t.Errorf("expected an error for %+v", opts.UDPClientOptions)
+		}
+	}
+}
+
+func TestBalancedClientCloseTwice(t *testing.T) {
+	opts := BalancedClientOptions{}
+	opts.StatsInterval = time.Second
+	c, err := DialBalancedUDP([]string{"127.0.0.1:25826"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+	_ = c.Close()
+}
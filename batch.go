@@ -0,0 +1,60 @@
+package cdclient
+
+// packetBatch accumulates finalized packets so they can be sent
+// with as few syscalls as the platform allows.
+type packetBatch struct {
+	buf     []byte
+	size    int
+	packets [][]byte
+	sender  batchSender
+}
+
+func newPacketBatch(n, size int) *packetBatch {
+	return &packetBatch{
+		buf:     make([]byte, n*size),
+		size:    size,
+		packets: make([][]byte, 0, n),
+	}
+}
+
+// add copies a finalized packet into the batch, the batch must not be full.
+func (b *packetBatch) add(p []byte) {
+	off := len(b.packets) * b.size
+	slot := b.buf[off : off+len(p)]
+	copy(slot, p)
+	b.packets = append(b.packets, slot)
+}
+
+func (b *packetBatch) full() bool {
+	return len(b.packets) == cap(b.packets)
+}
+
+// send writes all batched packets and records the outcome in stats,
+// the batch is emptied even if there is an error.
+func (b *packetBatch) send(conn Transport, stats *ClientStats) error {
+	if len(b.packets) == 0 {
+		return nil
+	}
+	sent, err := b.sender.send(conn, b.packets)
+	for _, p := range b.packets[:sent] {
+		stats.PacketsSent++
+		stats.BytesSent += uint64(len(p))
+	}
+	if err != nil {
+		stats.WriteErrors++
+		stats.PacketsDropped += uint64(len(b.packets) - sent)
+	}
+	b.packets = b.packets[:0]
+	return err
+}
+
+// writeEach writes packets one at a time, returning
+// how many were sent before any error.
+func writeEach(conn Transport, packets [][]byte) (int, error) {
+	for i, p := range packets {
+		if _, err := conn.Write(p); err != nil {
+			return i, err
+		}
+	}
+	return len(packets), nil
+}
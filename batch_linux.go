@@ -0,0 +1,87 @@
+package cdclient
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+}
+
+// batchSender sends a batch of packets with a single sendmmsg
+// syscall when the transport is a socket.
+type batchSender struct {
+	conn  Transport
+	rc    syscall.RawConn
+	msgs  []mmsghdr
+	iovs  []syscall.Iovec
+	n     int
+	sent  int
+	err   error
+	write func(fd uintptr) bool
+}
+
+func (s *batchSender) send(conn Transport, packets [][]byte) (int, error) {
+	if conn != s.conn {
+		s.conn = conn
+		s.rc = nil
+		if sc, ok := conn.(syscall.Conn); ok {
+			rc, err := sc.SyscallConn()
+			if err != nil {
+				return 0, err
+			}
+			s.rc = rc
+		}
+	}
+	if s.rc == nil {
+		return writeEach(conn, packets)
+	}
+
+	if len(s.msgs) < len(packets) {
+		s.msgs = make([]mmsghdr, len(packets))
+		s.iovs = make([]syscall.Iovec, len(packets))
+	}
+	for i, p := range packets {
+		s.iovs[i].Base = &p[0]
+		s.iovs[i].SetLen(len(p))
+		s.msgs[i] = mmsghdr{}
+		s.msgs[i].hdr.Iov = &s.iovs[i]
+		s.msgs[i].hdr.Iovlen = 1
+	}
+	s.n = len(packets)
+	s.sent = 0
+	s.err = nil
+	if s.write == nil {
+		s.write = s.sendmmsg
+	}
+	if err := s.rc.Write(s.write); err != nil {
+		return s.sent, err
+	}
+	return s.sent, s.err
+}
+
+func (s *batchSender) sendmmsg(fd uintptr) bool {
+	for s.sent < s.n {
+		n, _, errno := syscall.Syscall6(
+			sysSendmmsg,
+			fd,
+			uintptr(unsafe.Pointer(&s.msgs[s.sent])),
+			uintptr(s.n-s.sent),
+			0, 0, 0,
+		)
+		switch errno {
+		case 0:
+			s.sent += int(n)
+		case syscall.EINTR:
+		case syscall.EAGAIN:
+			// Wait for the socket to become writable.
+			return false
+		default:
+			s.err = errno
+			return true
+		}
+	}
+	return true
+}
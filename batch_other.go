@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package cdclient
+
+type batchSender struct{}
+
+func (s *batchSender) send(conn Transport, packets [][]byte) (int, error) {
+	return writeEach(conn, packets)
+}
@@ -4,6 +4,9 @@ package cdclient
 import (
 	"errors"
 	"fmt"
+	"math"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -33,6 +36,43 @@ type ValueList struct {
 	Values []float64
 }
 
+// MetricDefaults are used by clients for metrics
+// with an empty Host or a zero Interval.
+type MetricDefaults struct {
+	Host     string
+	Interval time.Duration
+}
+
+// DefaultsFromEnv reads the defaults collectd passes to exec plugins in
+// COLLECTD_HOSTNAME and COLLECTD_INTERVAL, the interval is in seconds.
+// Unset variables are left empty.
+func DefaultsFromEnv() (MetricDefaults, error) {
+	d := MetricDefaults{Host: os.Getenv("COLLECTD_HOSTNAME")}
+	if s := os.Getenv("COLLECTD_INTERVAL"); s != "" {
+		secs, err := strconv.ParseFloat(s, 64)
+		if err != nil || secs <= 0 || math.IsInf(secs, 0) {
+			return MetricDefaults{}, fmt.Errorf("invalid COLLECTD_INTERVAL %q", s)
+		}
+		d.Interval = time.Duration(secs * float64(time.Second))
+	}
+	return d, nil
+}
+
+// apply returns m, or a copy of m in tmp with the defaults filled in.
+func (d *MetricDefaults) apply(m *Metric, tmp *Metric) *Metric {
+	if (m.Host != "" || d.Host == "") && (m.Interval != 0 || d.Interval == 0) {
+		return m
+	}
+	*tmp = *m
+	if tmp.Host == "" {
+		tmp.Host = d.Host
+	}
+	if tmp.Interval == 0 {
+		tmp.Interval = d.Interval
+	}
+	return tmp
+}
+
 func (m *Metric) Validate() error {
 	if m.Interval == 0 {
 		return errors.New("interval is zero")
@@ -87,8 +127,33 @@ type MetricSink interface {
 
 type Packet interface {
 	MetricSink
+	// Finalize returns the packet contents, the result aliases
+	// internal buffers and must not be used after Reset.
 	Finalize() []byte
 	Reset()
 }
 
+// SizedPacket is implemented by the packets of this package, it
+// exposes their size so callers can decide when to flush them.
+type SizedPacket interface {
+	Packet
+	// AppendTo appends the finalized packet to dst.
+	AppendTo(dst []byte) []byte
+	// Len is the size of the finalized packet.
+	Len() int
+	// Available is the number of bytes left for metrics.
+	Available() int
+	// ValueListCount is the number of value lists added since Reset.
+	ValueListCount() int
+}
+
 var ErrPacketFull = errors.New("not enough space")
+
+// finalize finalizes p, using FinalizeErr when the
+// packet has it so failures are errors, not panics.
+func finalize(p Packet) ([]byte, error) {
+	if f, ok := p.(interface{ FinalizeErr() ([]byte, error) }); ok {
+		return f.FinalizeErr()
+	}
+	return p.Finalize(), nil
+}
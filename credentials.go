@@ -0,0 +1,75 @@
+package cdclient
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A CredentialProvider supplies the username and password for the
+// "Sign" and "Encrypt" modes. Clients resolve credentials when they
+// dial and on UDPClient.RefreshCredentials.
+type CredentialProvider interface {
+	Credentials() (username, password string, err error)
+}
+
+// AuthFileCredentials looks up Username in a collectd AuthFile,
+// the file is refreshed on every lookup.
+type AuthFileCredentials struct {
+	File     *AuthFile
+	Username string
+}
+
+func (c AuthFileCredentials) Credentials() (string, string, error) {
+	if _, err := c.File.Refresh(); err != nil {
+		return "", "", err
+	}
+	password, ok := c.File.Password(c.Username)
+	if !ok {
+		return "", "", fmt.Errorf("no password for %q in %s", c.Username, c.File.path)
+	}
+	return c.Username, password, nil
+}
+
+// EnvCredentials reads the username and password from the environment
+// variables named by UsernameVar and PasswordVar. When empty, the variables
+// COLLECTD_USERNAME and COLLECTD_PASSWORD are used.
+type EnvCredentials struct {
+	UsernameVar, PasswordVar string
+}
+
+func (c EnvCredentials) Credentials() (string, string, error) {
+	usernameVar := c.UsernameVar
+	if usernameVar == "" {
+		usernameVar = "COLLECTD_USERNAME"
+	}
+	passwordVar := c.PasswordVar
+	if passwordVar == "" {
+		passwordVar = "COLLECTD_PASSWORD"
+	}
+	username, ok := os.LookupEnv(usernameVar)
+	if !ok {
+		return "", "", fmt.Errorf("%s is not set", usernameVar)
+	}
+	password, ok := os.LookupEnv(passwordVar)
+	if !ok {
+		return "", "", fmt.Errorf("%s is not set", passwordVar)
+	}
+	return username, password, nil
+}
+
+// PasswordFileCredentials reads the password for Username from a file
+// containing only the password, such as a mounted Kubernetes secret.
+// Trailing line breaks are ignored.
+type PasswordFileCredentials struct {
+	Username string
+	Path     string
+}
+
+func (c PasswordFileCredentials) Credentials() (string, string, error) {
+	buf, err := os.ReadFile(c.Path)
+	if err != nil {
+		return "", "", err
+	}
+	return c.Username, strings.TrimRight(string(buf), "\r\n"), nil
+}
@@ -0,0 +1,78 @@
+package cdclient
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCredentialProviders(t *testing.T) {
+	dir := t.TempDir()
+	authPath := filepath.Join(dir, "collectd.auth")
+	if err := os.WriteFile(authPath, []byte("alice: w0nderl4nd\nbob: bu1|der\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	passwordPath := filepath.Join(dir, "password")
+	if err := os.WriteFile(passwordPath, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAuthFile(authPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CDCLIENT_TEST_USER", "carol")
+	os.Setenv("CDCLIENT_TEST_PASSWORD", "pa55")
+	defer os.Unsetenv("CDCLIENT_TEST_USER")
+	defer os.Unsetenv("CDCLIENT_TEST_PASSWORD")
+
+	for _, tc := range []struct {
+		provider           CredentialProvider
+		username, password string
+	}{
+		{AuthFileCredentials{File: a, Username: "bob"}, "bob", "bu1|der"},
+		{EnvCredentials{UsernameVar: "CDCLIENT_TEST_USER", PasswordVar: "CDCLIENT_TEST_PASSWORD"}, "carol", "pa55"},
+		{PasswordFileCredentials{Username: "dave", Path: passwordPath}, "dave", "s3cret"},
+	} {
+		username, password, err := tc.provider.Credentials()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if username != tc.username || password != tc.password {
+			t.Errorf("got %q %q, want %q %q", username, password, tc.username, tc.password)
+		}
+	}
+
+	if _, _, err := (AuthFileCredentials{File: a, Username: "eve"}).Credentials(); err == nil {
+		t.Error("expected error for unknown user")
+	}
+}
+
+func TestUDPClientRefreshCredentials(t *testing.T) {
+	passwordPath := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordPath, []byte("password"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tr := &MemoryTransport{}
+	c, err := NewUDPClient(tr, UDPClientOptions{
+		Mode:        UDPSign,
+		Credentials: PasswordFileCredentials{Username: "alice", Path: passwordPath},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(passwordPath, []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RefreshCredentials(); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.AddValues(testMetric(), time.Now(), 1)
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	packets := tr.Packets()
+	if len(packets) != 1 || !checkSignature(packets[0], "alice", "hunter2") {
+		t.Fatal("packet not signed with the refreshed credentials")
+	}
+}
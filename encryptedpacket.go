@@ -2,24 +2,23 @@ package cdclient
 
 import (
 	"bufio"
-	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/binary"
-	"errors"
+	"fmt"
 	"io"
+	"time"
 )
 
 type EncryptedPacket struct {
 	PlainTextPacket
-	username         []byte
-	ebuffer          bytes.Buffer
-	aesBlockCipher   cipher.Block
-	iv               []byte
-	cryptoRandReader *bufio.Reader
+	username       []byte
+	aesBlockCipher cipher.Block
+	iv             []byte
+	rand           io.Reader
 }
 
 func NewEncryptedPacket(username, password string) (*EncryptedPacket, error) {
@@ -27,54 +26,143 @@ func NewEncryptedPacket(username, password string) (*EncryptedPacket, error) {
 }
 
 func NewEncryptedPacketSize(username, password string, size int) (*EncryptedPacket, error) {
-	if len(username) > 64 {
-		return nil, errors.New("username must be 0-64 characters")
+	if size > MaxBufferSize {
+		return nil, fmt.Errorf("buffer size must be at most %d bytes", MaxBufferSize)
 	}
-	if len(password) > 64 {
-		return nil, errors.New("password must be 0-64 characters")
+	if err := checkUsername(username, size, 42); err != nil {
+		return nil, err
 	}
 	b := &EncryptedPacket{}
 	b.username = []byte(username)
-	passwordHash := sha256.Sum256([]byte(password))
-	aesBlockCipher, err := aes.NewCipher(passwordHash[:])
+	aesBlockCipher, err := newPasswordCipher(password)
 	if err != nil {
 		return nil, err
 	}
 	// Batching up crypto/rand reads makes then far faster.
 	// See https://github.com/golang/go/issues/16593
-	b.cryptoRandReader = bufio.NewReader(rand.Reader)
+	b.rand = bufio.NewReader(rand.Reader)
 	b.aesBlockCipher = aesBlockCipher
 	b.iv = make([]byte, 16)
-	b.PlainTextPacket.init(size - 42 - len(username))
+	b.PlainTextPacket.init(size-42-len(username), encryptedHeader(username))
 	return b, nil
 }
 
-func (b *EncryptedPacket) Finalize() []byte {
-	if b.buffer.Len() == 0 {
-		return nil
+// encryptedHeader returns the start of the encryption part up to the
+// metrics, the size, initialization vector and checksum are filled
+// in by Finalize.
+func encryptedHeader(username string) []byte {
+	header := make([]byte, 42+len(username))
+	binary.BigEndian.PutUint16(header[0:2], uint16(typeEncryptAES256))
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(username)))
+	copy(header[6:], username)
+	return header
+}
+
+func newPasswordCipher(password string) (cipher.Block, error) {
+	passwordHash := sha256.Sum256([]byte(password))
+	return aes.NewCipher(passwordHash[:])
+}
+
+// SetCredentials changes the username and password used to encrypt the
+// packet, any buffered metrics are encrypted with the new credentials.
+func (b *EncryptedPacket) SetCredentials(username, password string) error {
+	size := b.size + 42 + len(b.username)
+	if err := checkUsername(username, size, 42); err != nil {
+		return err
+	}
+	b.unseal()
+	aesBlockCipher, err := newPasswordCipher(password)
+	if err != nil {
+		return err
 	}
-	plainText := b.buffer.Bytes()
-	b.ebuffer.Reset()
-	if _, err := io.ReadFull(b.cryptoRandReader, b.iv); err != nil {
+	err = b.setHeader(encryptedHeader(username), size-42-len(username))
+	if err != nil {
+		return err
+	}
+	b.aesBlockCipher = aesBlockCipher
+	b.username = []byte(username)
+	return nil
+}
+
+// SetRandom sets the source of initialization vectors, by default a
+// buffered crypto/rand reader. r is used as is, so slow readers should
+// be buffered. A deterministic reader is useful for golden tests but
+// must never be used to send real traffic.
+func (b *EncryptedPacket) SetRandom(r io.Reader) {
+	b.rand = r
+}
+
+// Finalize encrypts the packet in place, it panics if an initialization
+// vector cannot be read, see FinalizeErr. Metrics added afterwards first
+// decrypt the packet again.
+func (b *EncryptedPacket) Finalize() []byte {
+	out, err := b.FinalizeErr()
+	if err != nil {
 		panic(err) // just die if this ever happens.
 	}
-	size := uint16(42 + len(b.username) + len(plainText))
-	tmp := [6]byte{}
-	binary.BigEndian.PutUint16(tmp[0:2], uint16(typeEncryptAES256))
-	binary.BigEndian.PutUint16(tmp[2:4], size)
-	binary.BigEndian.PutUint16(tmp[4:6], uint16(len(b.username)))
-	b.ebuffer.Write(tmp[:])
-	b.ebuffer.Write(b.username)
-	b.ebuffer.Write(b.iv)
-	pos := b.ebuffer.Len()
-	checksum := sha1.Sum(plainText)
-	b.ebuffer.Write(checksum[:])
-	b.ebuffer.Write(plainText)
-	out := b.ebuffer.Bytes()
-	aesOfb(b.aesBlockCipher, b.iv, out[pos:])
 	return out
 }
 
+// FinalizeErr is like Finalize, but returns an error
+// if an initialization vector cannot be read.
+func (b *EncryptedPacket) FinalizeErr() ([]byte, error) {
+	if b.Len() == 0 {
+		return nil, nil
+	}
+	out := b.buffer.Bytes()
+	if b.sealed {
+		return out, nil
+	}
+	ivPos := 6 + len(b.username)
+	if _, err := io.ReadFull(b.rand, b.iv); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(out[2:4], uint16(len(out)))
+	copy(out[ivPos:], b.iv)
+	checksum := sha1.Sum(b.body())
+	copy(out[ivPos+16:], checksum[:])
+	aesOfb(b.aesBlockCipher, b.iv, out[ivPos+16:])
+	b.sealed = true
+	return out, nil
+}
+
+// unseal decrypts a finalized packet in place, OFB mode
+// decrypts by applying the same key stream again.
+func (b *EncryptedPacket) unseal() {
+	if !b.sealed {
+		return
+	}
+	out := b.buffer.Bytes()
+	ivPos := 6 + len(b.username)
+	copy(b.iv, out[ivPos:ivPos+16])
+	aesOfb(b.aesBlockCipher, b.iv, out[ivPos+16:])
+	b.sealed = false
+}
+
+func (b *EncryptedPacket) AddValues(m *Metric, t time.Time, values ...float64) error {
+	b.unseal()
+	return b.PlainTextPacket.AddValues(m, t, values...)
+}
+
+func (b *EncryptedPacket) AddValueList(v ValueList) error {
+	b.unseal()
+	return b.PlainTextPacket.AddValueList(v)
+}
+
+func (b *EncryptedPacket) AddNotification(n *Notification) error {
+	b.unseal()
+	return b.PlainTextPacket.AddNotification(n)
+}
+
+func (b *EncryptedPacket) plainText() *PlainTextPacket {
+	b.unseal()
+	return &b.PlainTextPacket
+}
+
+func (b *EncryptedPacket) AppendTo(dst []byte) []byte {
+	return append(dst, b.Finalize()...)
+}
+
 func aesOfb(b cipher.Block, iv []byte, buf []byte) {
 	blockSize := 16
 	cipher := iv
@@ -1,10 +1,17 @@
 package cdclient
 
 import (
+	"bytes"
 	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"math"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -62,3 +69,79 @@ func TestAesOfb(t *testing.T) {
 		t.Fatalf("aesOfb gave an unexpected result")
 	}
 }
+
+func TestEncryptedPacketGolden(t *testing.T) {
+	password := strings.Repeat("p", 128)
+	b, err := NewEncryptedPacket("alice", password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := bytes.Repeat([]byte{7}, 16)
+	b.SetRandom(bytes.NewReader(iv))
+	_ = b.AddValues(testMetric(), time.Unix(1426076671, 0), 1)
+
+	plain := NewPlainTextPacket()
+	_ = plain.AddValues(testMetric(), time.Unix(1426076671, 0), 1)
+	plainText := plain.Finalize()
+
+	// Build the expected packet with the standard library.
+	checksum := sha1.Sum(plainText)
+	key := sha256.Sum256([]byte(password))
+	block, _ := aes.NewCipher(key[:])
+	encrypted := append(checksum[:], plainText...)
+	cipher.NewOFB(block, iv).XORKeyStream(encrypted, encrypted)
+	want := []byte{0x02, 0x10, 0, byte(42 + 5 + len(plainText)), 0, 5}
+	want = append(want, "alice"...)
+	want = append(want, iv...)
+	want = append(want, encrypted...)
+
+	got, err := b.FinalizeErr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %x, want %x", got, want)
+	}
+}
+
+func TestEncryptedPacketAddAfterFinalize(t *testing.T) {
+	b, _ := NewEncryptedPacket("alice", "password")
+	b.SetRandom(bytes.NewReader(bytes.Repeat([]byte{7}, 32)))
+	_ = b.AddValues(testMetric(), time.Unix(1426076671, 0), 1)
+	b.Finalize()
+	if err := b.AddValues(testMetric(), time.Unix(1426076672, 0), 2); err != nil {
+		t.Fatal(err)
+	}
+	got := b.Finalize()
+
+	want, _ := NewEncryptedPacket("alice", "password")
+	want.SetRandom(bytes.NewReader(bytes.Repeat([]byte{7}, 16)))
+	_ = want.AddValues(testMetric(), time.Unix(1426076671, 0), 1)
+	_ = want.AddValues(testMetric(), time.Unix(1426076672, 0), 2)
+	if !bytes.Equal(got, want.Finalize()) {
+		t.Fatal("metrics added after Finalize were not encrypted with the others")
+	}
+}
+
+func TestEncryptedPacketFinalizeErr(t *testing.T) {
+	b, _ := NewEncryptedPacket("alice", "password")
+	b.SetRandom(iotest.ErrReader(errors.New("no entropy")))
+	_ = b.AddValues(testMetric(), time.Unix(1426076671, 0), 1)
+	if _, err := b.FinalizeErr(); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func BenchmarkFinalizeFullEncryptedPacket(bench *testing.B) {
+	b, _ := NewEncryptedPacket("username", "password")
+	fillPacket(b, ValueList{Metric: testMetric(), Values: []float64{1}})
+	bench.ReportAllocs()
+	bench.SetBytes(int64(len(b.Finalize())))
+	bench.ResetTimer()
+	for n := 0; n < bench.N; n++ {
+		// Encrypt the already encrypted contents again,
+		// this only measures the cost of finalizing.
+		b.sealed = false
+		b.Finalize()
+	}
+}
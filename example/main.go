@@ -1,8 +1,8 @@
 package main
 
 import (
+	"errors"
 	"flag"
-	"fmt"
 	"os"
 	"path"
 	"runtime"
@@ -15,25 +15,37 @@ var (
 	authfile = flag.String("authfile", "./collectd.auth", "collectd Password")
 	username = flag.String("username", "metrics", "collectd auth username")
 	mode     = flag.String("mode", "encrypt", "Mode, one of 'plain-text', 'sign', 'encrypt'")
+	config   = flag.String("config", "", "collectd.conf to read the network plugin server from, instead of the other flags")
 )
 
-func main() {
-
-	flag.Parse()
-
-	auth, err := cdclient.NewAuthFile(*authfile)
+// metricDefaults are collectd's hostname and interval when run by the exec
+// plugin, otherwise the hostname and one second.
+func metricDefaults() (cdclient.MetricDefaults, error) {
+	d, err := cdclient.DefaultsFromEnv()
 	if err != nil {
-		panic(err)
+		return d, err
+	}
+	if d.Host == "" {
+		d.Host, _ = os.Hostname()
+	}
+	if d.Interval == 0 {
+		d.Interval = 1 * time.Second
 	}
+	return d, nil
+}
 
-	password, ok := auth.Password(*username)
-	if !ok {
-		panic(fmt.Sprintf("no password for: %s", *username))
+func dialFlags(defaults cdclient.MetricDefaults) (*cdclient.UDPClient, error) {
+	auth, err := cdclient.NewAuthFile(*authfile)
+	if err != nil {
+		return nil, err
 	}
 
 	opts := cdclient.UDPClientOptions{
-		Username: *username,
-		Password: password,
+		Credentials: cdclient.AuthFileCredentials{
+			File:     auth,
+			Username: *username,
+		},
+		Defaults: defaults,
 	}
 
 	switch *mode {
@@ -44,20 +56,48 @@ func main() {
 	case "encrypt":
 		opts.Mode = cdclient.UDPEncrypt
 	default:
-		panic("invalid -mode")
+		return nil, errors.New("invalid -mode")
 	}
 
-	c, err := cdclient.DialUDP("127.0.0.1:25826", opts)
+	return cdclient.DialUDP("127.0.0.1:25826", opts)
+}
+
+func dialConfig(defaults cdclient.MetricDefaults) (*cdclient.UDPClient, error) {
+	servers, err := cdclient.LoadNetworkConfig(*config)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("no network plugin servers in -config")
+	}
+	opts := servers[0].Options
+	opts.Defaults = defaults
+	return cdclient.DialUDP(servers[0].Address, opts)
+}
+
+func main() {
+
+	flag.Parse()
+
+	defaults, err := metricDefaults()
+	if err != nil {
+		panic(err)
+	}
+
+	var c *cdclient.UDPClient
+	if *config != "" {
+		c, err = dialConfig(defaults)
+	} else {
+		c, err = dialFlags(defaults)
+	}
 	if err != nil {
 		panic(err)
 	}
 
 	memStats := runtime.MemStats{}
-	interval := 1 * time.Second
-	host, _ := os.Hostname()
 
+	// Host and Interval are filled in from the defaults.
 	total_alloc := &cdclient.Metric{
-		Host:           host,
 		Plugin:         "go",
 		PluginInstance: path.Base(os.Args[0]),
 		Type:           "counter",
@@ -65,11 +105,8 @@ func main() {
 		DSTypes: []cdclient.DSType{
 			cdclient.COUNTER,
 		},
-		Interval: interval,
 	}
 
-	total_alloc.Validate()
-
 	for {
 		runtime.ReadMemStats(&memStats)
 		err := c.AddValues(
@@ -82,7 +119,7 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
-		time.Sleep(interval)
+		time.Sleep(defaults.Interval)
 	}
 
 }
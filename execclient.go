@@ -0,0 +1,185 @@
+package cdclient
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// DefaultExecBufferSize is the default number of bytes
+// an ExecClient buffers before writing.
+const DefaultExecBufferSize = 4096
+
+type ExecClientOptions struct {
+	// Writer receives the PUTVAL lines, when nil os.Stdout is used.
+	Writer io.Writer
+	// BufferSize is the number of bytes buffered before writing.
+	// When zero, DefaultExecBufferSize is used.
+	BufferSize int
+	// FlushInterval is how often buffered lines are written.
+	// When zero lines are only written once the buffer is full or on Flush.
+	FlushInterval time.Duration
+	// NoInterval and IntegerTime are passed to the ExecFormatter.
+	NoInterval  bool
+	IntegerTime bool
+	// Defaults fill in the Host and Interval of metrics without them,
+	// see DefaultsFromEnv.
+	Defaults MetricDefaults
+	// ExitOnClose exits the process when collectd stops the exec plugin,
+	// either by closing the pipe or by sending SIGTERM, after writing any
+	// buffered lines where possible.
+	ExitOnClose bool
+}
+
+// ExecClient writes metrics in the collectd exec plugin format.
+// The client is safe to use from multiple goroutines concurrently.
+type ExecClient struct {
+	lock        sync.Mutex
+	w           io.Writer
+	ef          ExecFormatter
+	size        int
+	defaults    MetricDefaults
+	tmpMetric   Metric
+	exitOnClose bool
+	exit        func(int)
+	stats       ClientStats
+	closed      bool
+	signals     chan os.Signal
+	stop        chan struct{}
+}
+
+func NewExecClient(opts ExecClientOptions) *ExecClient {
+	if opts.Writer == nil {
+		opts.Writer = os.Stdout
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultExecBufferSize
+	}
+	c := &ExecClient{
+		w:           opts.Writer,
+		size:        opts.BufferSize,
+		defaults:    opts.Defaults,
+		exitOnClose: opts.ExitOnClose,
+		exit:        os.Exit,
+		stop:        make(chan struct{}),
+	}
+	c.ef.NoInterval = opts.NoInterval
+	c.ef.IntegerTime = opts.IntegerTime
+	if opts.ExitOnClose && len(execSignals) > 0 {
+		c.signals = make(chan os.Signal, 1)
+		signal.Notify(c.signals, execSignals...)
+		go c.handleSignals(c.signals)
+	}
+	if opts.FlushInterval > 0 {
+		go c.tick(opts.FlushInterval)
+	}
+	return c
+}
+
+func (c *ExecClient) handleSignals(signals <-chan os.Signal) {
+	for {
+		select {
+		case sig := <-signals:
+			if isTermSignal(sig) {
+				_ = c.Flush()
+				c.exit(0)
+				return
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *ExecClient) tick(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			_ = c.Flush()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *ExecClient) AddValues(m *Metric, t time.Time, values ...float64) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return ErrClientClosed
+	}
+	m = c.defaults.apply(m, &c.tmpMetric)
+	if err := c.ef.AddValues(m, t, values...); err != nil {
+		return err
+	}
+	return c.maybeFlush()
+}
+
+func (c *ExecClient) AddValueList(v ValueList) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return ErrClientClosed
+	}
+	v.Metric = c.defaults.apply(v.Metric, &c.tmpMetric)
+	if err := c.ef.AddValueList(v); err != nil {
+		return err
+	}
+	return c.maybeFlush()
+}
+
+func (c *ExecClient) maybeFlush() error {
+	if c.ef.Len() < c.size {
+		return nil
+	}
+	c.stats.FullFlushes++
+	return c.flush()
+}
+
+func (c *ExecClient) flush() error {
+	buf := c.ef.Finalize()
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := c.w.Write(buf)
+	c.stats.countWrite(len(buf), err)
+	c.ef.Reset()
+	if err != nil && c.exitOnClose && isBrokenPipe(err) {
+		// collectd has stopped reading, there is nobody left to report to.
+		c.exit(0)
+	}
+	return err
+}
+
+// Flush writes any buffered lines.
+func (c *ExecClient) Flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.flush()
+}
+
+func (c *ExecClient) Stats() ClientStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.stats
+}
+
+// Close writes any buffered lines and stops the background goroutines,
+// the writer is not closed.
+func (c *ExecClient) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return ErrClientClosed
+	}
+	c.closed = true
+	close(c.stop)
+	if c.signals != nil {
+		signal.Stop(c.signals)
+	}
+	return c.flush()
+}
@@ -0,0 +1,141 @@
+package cdclient
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a bytes.Buffer safe for use from several goroutines.
+type lockedBuffer struct {
+	lock   sync.Mutex
+	buf    bytes.Buffer
+	writes int
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.writes++
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestExecClient(t *testing.T) {
+	w := &lockedBuffer{}
+	c := NewExecClient(ExecClientOptions{Writer: w})
+	now := time.Unix(1426076671, 0)
+	if err := c.AddValues(testMetric(), now, 1); err != nil {
+		t.Fatal(err)
+	}
+	if w.String() != "" {
+		t.Fatalf("got %q before flushing", w.String())
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "putval example.com/golang/gauge interval=10 1426076671:1\n"
+	if w.String() != expected {
+		t.Fatalf("%q != (expected)%q", w.String(), expected)
+	}
+	if err := c.AddValues(testMetric(), now, 1); err != ErrClientClosed {
+		t.Fatalf("got %v, want ErrClientClosed", err)
+	}
+}
+
+func TestExecClientBufferSize(t *testing.T) {
+	w := &lockedBuffer{}
+	c := NewExecClient(ExecClientOptions{Writer: w, BufferSize: 100})
+	defer c.Close()
+	now := time.Unix(1426076671, 0)
+	for i := 0; i < 2; i++ {
+		if err := c.AddValues(testMetric(), now, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.writes != 1 || strings.Count(w.String(), "\n") != 2 {
+		t.Fatalf("got %d writes of %q", w.writes, w.String())
+	}
+	if c.Stats().FullFlushes != 1 {
+		t.Fatalf("got %d full flushes, want 1", c.Stats().FullFlushes)
+	}
+}
+
+func TestExecClientFlushInterval(t *testing.T) {
+	w := &lockedBuffer{}
+	c := NewExecClient(ExecClientOptions{Writer: w, FlushInterval: 10 * time.Millisecond})
+	defer c.Close()
+	if err := c.AddValues(testMetric(), time.Unix(1426076671, 0), 1); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for w.String() == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("values were not flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestExecClientConcurrent(t *testing.T) {
+	w := &lockedBuffer{}
+	c := NewExecClient(ExecClientOptions{Writer: w, BufferSize: 256})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := testMetric()
+			for j := 0; j < 100; j++ {
+				_ = c.AddValues(m, time.Unix(1426076671, 0), float64(j))
+			}
+		}()
+	}
+	wg.Wait()
+	_ = c.Close()
+	if n := strings.Count(w.String(), "putval "); n != 800 {
+		t.Fatalf("got %d lines, want 800", n)
+	}
+}
+
+func TestExecClientDefaultsFromEnv(t *testing.T) {
+	os.Setenv("COLLECTD_HOSTNAME", "collectd.example.com")
+	os.Setenv("COLLECTD_INTERVAL", "0.5")
+	defer os.Unsetenv("COLLECTD_HOSTNAME")
+	defer os.Unsetenv("COLLECTD_INTERVAL")
+	d, err := DefaultsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Host != "collectd.example.com" || d.Interval != 500*time.Millisecond {
+		t.Fatalf("got %+v", d)
+	}
+
+	w := &lockedBuffer{}
+	c := NewExecClient(ExecClientOptions{Writer: w, Defaults: d})
+	m := &Metric{Plugin: "golang", Type: "gauge", DSTypes: []DSType{GAUGE}}
+	if err := c.AddValues(m, time.Unix(1426076671, 0), 1); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+	expected := "putval collectd.example.com/golang/gauge interval=0.5 1426076671:1\n"
+	if w.String() != expected {
+		t.Fatalf("%q != (expected)%q", w.String(), expected)
+	}
+	if m.Host != "" || m.Interval != 0 {
+		t.Fatal("the metric was modified")
+	}
+
+	os.Setenv("COLLECTD_INTERVAL", "soon")
+	if _, err := DefaultsFromEnv(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -2,6 +2,9 @@ package cdclient
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"math"
 	"strconv"
 	"time"
 )
@@ -10,7 +13,11 @@ type ExecFormatter struct {
 	buffer     bytes.Buffer
 	tmpBuf     []byte
 	tmpValues  []float64
+	count      int
 	NoInterval bool
+	// IntegerTime truncates times and intervals to whole seconds, as
+	// expected by collectd versions before 5.0.
+	IntegerTime bool
 }
 
 func (ef *ExecFormatter) AddValues(m *Metric, t time.Time, values ...float64) error {
@@ -24,46 +31,164 @@ func (ef *ExecFormatter) AddValues(m *Metric, t time.Time, values ...float64) er
 }
 
 func (ef *ExecFormatter) AddValueList(vl ValueList) error {
+	l := ef.buffer.Len()
+	if err := ef.addValueList(vl); err != nil {
+		ef.buffer.Truncate(l)
+		return err
+	}
+	ef.count++
+	return nil
+}
+
+func (ef *ExecFormatter) addValueList(vl ValueList) error {
 	m := vl.Metric
+	if len(vl.Values) != len(m.DSTypes) {
+		return errors.New("value count does not match the metric")
+	}
 	ef.buffer.WriteString("putval ")
-	ef.buffer.WriteString(m.Host)
+	if err := ef.writeIdentifier(m); err != nil {
+		return err
+	}
+	ef.buffer.WriteByte(' ')
+	if !ef.NoInterval {
+		ef.buffer.WriteString("interval=")
+		d := m.Interval
+		ef.appendSeconds(int64(d/time.Second), int64(d%time.Second))
+		ef.buffer.WriteByte(' ')
+	}
+
+	ef.appendSeconds(vl.Time.Unix(), int64(vl.Time.Nanosecond()))
+	for i, v := range vl.Values {
+		ef.buffer.WriteByte(':')
+		if err := ef.appendValue(m.DSTypes[i], v); err != nil {
+			return err
+		}
+	}
+
+	ef.buffer.WriteByte('\n')
+	return nil
+}
+
+// writeIdentifier writes the identifier, quoted if it contains whitespace,
+// quotes or backslashes, as collectd splits commands on whitespace.
+func (ef *ExecFormatter) writeIdentifier(m *Metric) error {
+	if m.Host == "" || m.Plugin == "" || m.Type == "" {
+		return errors.New("host, plugin and type must not be empty")
+	}
+	quote := false
+	for i, s := range [...]string{m.Host, m.Plugin, m.PluginInstance, m.Type, m.TypeInstance} {
+		for j := 0; j < len(s); j++ {
+			switch c := s[j]; c {
+			case '\n', '\r', 0, '/':
+				return fmt.Errorf("identifier %q contains %q", s, c)
+			case '-':
+				// collectd splits the plugin and type instances at the first '-'.
+				if i == 1 || i == 3 {
+					return fmt.Errorf("identifier %q contains %q", s, c)
+				}
+			case ' ', '\t', '\v', '\f', '"', '\\':
+				quote = true
+			}
+		}
+	}
+	if quote {
+		ef.buffer.WriteByte('"')
+	}
+	ef.writeEscaped(m.Host, quote)
 	ef.buffer.WriteByte('/')
-	ef.buffer.WriteString(m.Plugin)
+	ef.writeEscaped(m.Plugin, quote)
 	if m.PluginInstance != "" {
 		ef.buffer.WriteByte('-')
-		ef.buffer.WriteString(m.PluginInstance)
+		ef.writeEscaped(m.PluginInstance, quote)
 	}
 	ef.buffer.WriteByte('/')
-	ef.buffer.WriteString(m.Type)
+	ef.writeEscaped(m.Type, quote)
 	if m.TypeInstance != "" {
 		ef.buffer.WriteByte('-')
-		ef.buffer.WriteString(m.TypeInstance)
+		ef.writeEscaped(m.TypeInstance, quote)
 	}
-	ef.buffer.WriteByte(' ')
-	if !ef.NoInterval {
-		ef.buffer.WriteString("interval=")
-		ef.tmpBuf = strconv.AppendInt(ef.tmpBuf[:0], int64(m.Interval.Seconds()), 10)
-		ef.buffer.Write(ef.tmpBuf)
-		ef.buffer.WriteByte(' ')
+	if quote {
+		ef.buffer.WriteByte('"')
 	}
+	return nil
+}
 
-	ef.tmpBuf = strconv.AppendInt(ef.tmpBuf[:0], vl.Time.Unix(), 10)
-	ef.buffer.Write(ef.tmpBuf)
-	for _, v := range vl.Values {
-		ef.buffer.WriteByte(':')
-		ef.tmpBuf = strconv.AppendFloat(ef.tmpBuf[:0], v, 'f', -1, 64)
-		ef.buffer.Write(ef.tmpBuf)
+func (ef *ExecFormatter) writeEscaped(s string, escape bool) {
+	if !escape {
+		ef.buffer.WriteString(s)
+		return
 	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			ef.buffer.WriteByte('\\')
+		}
+		ef.buffer.WriteByte(s[i])
+	}
+}
 
-	ef.buffer.WriteByte('\n')
+// appendValue writes v as collectd's PUTVAL parser expects it, unknown
+// gauges are "U" and the other types must be whole numbers.
+func (ef *ExecFormatter) appendValue(t DSType, v float64) error {
+	switch t {
+	case GAUGE:
+		if math.IsNaN(v) {
+			ef.buffer.WriteByte('U')
+			return nil
+		}
+		ef.tmpBuf = strconv.AppendFloat(ef.tmpBuf[:0], v, 'f', -1, 64)
+	case DERIVE:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return fmt.Errorf("derive value %v is not an integer", v)
+		}
+		ef.tmpBuf = strconv.AppendInt(ef.tmpBuf[:0], int64(v), 10)
+	case COUNTER, ABSOLUTE:
+		if v != math.Trunc(v) || v < 0 || v >= math.MaxUint64 {
+			return fmt.Errorf("counter value %v is not a non-negative integer", v)
+		}
+		ef.tmpBuf = strconv.AppendUint(ef.tmpBuf[:0], uint64(v), 10)
+	default:
+		return errors.New("value type is out of range")
+	}
+	ef.buffer.Write(ef.tmpBuf)
 	return nil
 }
 
+// appendSeconds writes seconds with any fraction, trimming trailing zeros.
+func (ef *ExecFormatter) appendSeconds(sec, nsec int64) {
+	ef.tmpBuf = strconv.AppendInt(ef.tmpBuf[:0], sec, 10)
+	if nsec != 0 && !ef.IntegerTime {
+		ef.tmpBuf = append(ef.tmpBuf, '.')
+		for div := int64(100000000); nsec != 0; div /= 10 {
+			ef.tmpBuf = append(ef.tmpBuf, byte('0'+nsec/div))
+			nsec %= div
+		}
+	}
+	ef.buffer.Write(ef.tmpBuf)
+}
+
 func (ef *ExecFormatter) Finalize() []byte {
 	return ef.buffer.Bytes()
 }
 
+func (ef *ExecFormatter) AppendTo(dst []byte) []byte {
+	return append(dst, ef.buffer.Bytes()...)
+}
+
+func (ef *ExecFormatter) Len() int {
+	return ef.buffer.Len()
+}
+
+// Available is unlimited, the exec format has no packet size.
+func (ef *ExecFormatter) Available() int {
+	return int(^uint(0) >> 1)
+}
+
+func (ef *ExecFormatter) ValueListCount() int {
+	return ef.count
+}
+
 func (ef *ExecFormatter) Reset() {
 	ef.buffer.Reset()
+	ef.count = 0
 	ef.tmpBuf = ef.tmpBuf[:0]
 }
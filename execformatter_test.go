@@ -15,7 +15,7 @@ func TestExecFormatter(t *testing.T) {
 		PluginInstance: "foo",
 		Type:           "gauge",
 		TypeInstance:   "bar",
-		DSTypes:        []DSType{DERIVE, COUNTER},
+		DSTypes:        []DSType{DERIVE, GAUGE},
 		Interval:       10 * time.Second,
 	}
 
@@ -28,12 +28,125 @@ func TestExecFormatter(t *testing.T) {
 	_ = ef.AddValueList(v)
 
 	got := string(ef.Finalize())
-	expected := "putval example.com/golang-foo/gauge-bar interval=10 1426076671:1:12.3\n"
+	expected := "putval example.com/golang-foo/gauge-bar interval=10 1426076671.123:1:12.3\n"
 	if got != expected {
 		t.Fatalf("%q != (expected)%q", got, expected)
 	}
 }
 
+func TestExecFormatterTime(t *testing.T) {
+	m := Metric{
+		Host:    "example.com",
+		Plugin:  "golang",
+		Type:    "gauge",
+		DSTypes: []DSType{GAUGE},
+	}
+	for _, tc := range []struct {
+		interval time.Duration
+		time     time.Time
+		integer  bool
+		expected string
+	}{
+		{500 * time.Millisecond, time.Unix(1426076671, 0), false, "interval=0.5 1426076671"},
+		{10*time.Second + time.Nanosecond, time.Unix(1426076671, 1), false, "interval=10.000000001 1426076671.000000001"},
+		{1500 * time.Millisecond, time.Unix(1426076671, 120000000), false, "interval=1.5 1426076671.12"},
+		{1500 * time.Millisecond, time.Unix(1426076671, 120000000), true, "interval=1 1426076671"},
+	} {
+		ef := ExecFormatter{IntegerTime: tc.integer}
+		m.Interval = tc.interval
+		_ = ef.AddValues(&m, tc.time, 1)
+		got := string(ef.Finalize())
+		expected := "putval example.com/golang/gauge " + tc.expected + ":1\n"
+		if got != expected {
+			t.Errorf("%q != (expected)%q", got, expected)
+		}
+	}
+}
+
+func TestExecFormatterValues(t *testing.T) {
+	m := Metric{
+		Host:   "example.com",
+		Plugin: "golang",
+		Type:   "gauge",
+	}
+	now := time.Unix(1426076671, 0)
+	for _, tc := range []struct {
+		dsType   DSType
+		value    float64
+		expected string
+	}{
+		{GAUGE, math.NaN(), "U"},
+		{GAUGE, -1.5, "-1.5"},
+		{DERIVE, -3, "-3"},
+		{COUNTER, 18446744073709549568, "18446744073709549568"},
+		{ABSOLUTE, 7, "7"},
+		{DERIVE, math.NaN(), ""},
+		{DERIVE, 1.5, ""},
+		{DERIVE, math.Inf(1), ""},
+		{COUNTER, -1, ""},
+		{COUNTER, 12.3, ""},
+		{ABSOLUTE, math.NaN(), ""},
+	} {
+		ef := ExecFormatter{NoInterval: true}
+		m.DSTypes = []DSType{tc.dsType}
+		err := ef.AddValues(&m, now, tc.value)
+		got := string(ef.Finalize())
+		if tc.expected == "" {
+			if err == nil || got != "" || ef.ValueListCount() != 0 {
+				t.Errorf("%v: expected an error and no output, got %q", tc.value, got)
+			}
+			continue
+		}
+		expected := "putval example.com/golang/gauge 1426076671:" + tc.expected + "\n"
+		if err != nil || got != expected {
+			t.Errorf("%q, %v != (expected)%q", got, err, expected)
+		}
+	}
+}
+
+func TestExecFormatterIdentifier(t *testing.T) {
+	for _, tc := range []struct {
+		pluginInstance, typeInstance string
+		expected                     string
+	}{
+		{"a-b", "", "example.com/golang-a-b/gauge"},
+		{"a b", "", `"example.com/golang-a b/gauge"`},
+		{"", `say "hi"`, `"example.com/golang/gauge-say \"hi\""`},
+		{`c:\`, "\t", `"example.com/golang-c:\\/gauge-` + "\t" + `"`},
+		{"a\nb", "", ""},
+		{"a/b", "", ""},
+		{"", "a\x00", ""},
+	} {
+		m := Metric{
+			Host:           "example.com",
+			Plugin:         "golang",
+			PluginInstance: tc.pluginInstance,
+			Type:           "gauge",
+			TypeInstance:   tc.typeInstance,
+			DSTypes:        []DSType{GAUGE},
+		}
+		ef := ExecFormatter{NoInterval: true}
+		err := ef.AddValues(&m, time.Unix(1426076671, 0), 1)
+		got := string(ef.Finalize())
+		if tc.expected == "" {
+			if err == nil || got != "" {
+				t.Errorf("expected an error and no output, got %q", got)
+			}
+			continue
+		}
+		expected := "putval " + tc.expected + " 1426076671:1\n"
+		if err != nil || got != expected {
+			t.Errorf("%q, %v != (expected)%q", got, err, expected)
+		}
+	}
+
+	m := Metric{Host: "example.com", Plugin: "go-lang", Type: "gauge", DSTypes: []DSType{GAUGE}}
+	ef := ExecFormatter{}
+	if err := ef.AddValues(&m, time.Unix(1426076671, 0), 1); err == nil {
+		t.Error("expected an error for '-' in the plugin")
+	}
+}
+
 func BenchmarkExecFormatter(bench *testing.B) {
 	ef := ExecFormatter{}
 	bench.ReportAllocs()
@@ -41,7 +154,7 @@ func BenchmarkExecFormatter(bench *testing.B) {
 		Host:     "example.com",
 		Plugin:   "golang",
 		Type:     "gauge",
-		DSTypes:  []DSType{DERIVE, COUNTER},
+		DSTypes:  []DSType{DERIVE, GAUGE},
 		Interval: 10 * time.Second,
 	}
 	t := time.Unix(1426076671, 123000000)
@@ -55,3 +168,26 @@ func BenchmarkExecFormatter(bench *testing.B) {
 		ef.Reset()
 	}
 }
+
+func BenchmarkExecFormatterQuoted(bench *testing.B) {
+	ef := ExecFormatter{}
+	bench.ReportAllocs()
+	m := Metric{
+		Host:         "example.com",
+		Plugin:       "golang",
+		Type:         "gauge",
+		TypeInstance: `say "hi"`,
+		DSTypes:      []DSType{DERIVE, GAUGE},
+		Interval:     10 * time.Second,
+	}
+	t := time.Unix(1426076671, 123000000)
+	_ = ef.AddValues(&m, t, 1, math.NaN())
+	ef.Finalize()
+	ef.Reset()
+	bench.ResetTimer()
+	for n := 0; n < bench.N; n++ {
+		ef.AddValues(&m, t, 1, math.NaN())
+		ef.Finalize()
+		ef.Reset()
+	}
+}
@@ -0,0 +1,514 @@
+package cdclient
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExecCommand is a program run by an ExecRunner, like
+// an Exec line of collectd's exec plugin.
+type ExecCommand struct {
+	Path string
+	Args []string
+	// User and Group run the program as another user, when Group
+	// is empty the user's primary group is used.
+	User  string
+	Group string
+	// Env is added to the environment of the program.
+	Env []string
+}
+
+const (
+	DefaultMinRestartDelay = 1 * time.Second
+	DefaultMaxRestartDelay = 1 * time.Minute
+	// DefaultKillTimeout is how long Close waits for programs
+	// to exit after SIGTERM before killing them.
+	DefaultKillTimeout = 10 * time.Second
+)
+
+type ExecRunnerOptions struct {
+	// Defaults are passed to programs as COLLECTD_HOSTNAME and
+	// COLLECTD_INTERVAL, and fill in metrics without an interval.
+	Defaults MetricDefaults
+	// Types are the data source types of metric types, when
+	// nil a few common single value types are known.
+	Types TypesDB
+	// Logger receives the standard error of programs and
+	// lines that could not be parsed, when nil log.Default() is used.
+	Logger *log.Logger
+	// MinRestartDelay and MaxRestartDelay bound the delay before a
+	// program that exited is started again, the delay doubles after
+	// each restart and is reset once a program runs for MaxRestartDelay.
+	// When zero, DefaultMinRestartDelay and DefaultMaxRestartDelay are used.
+	MinRestartDelay time.Duration
+	MaxRestartDelay time.Duration
+	// KillTimeout is how long Close waits for programs to exit before
+	// killing them. When zero, DefaultKillTimeout is used.
+	KillTimeout time.Duration
+	// FlushInterval is how often the sink is flushed, if it has a Flush
+	// method. When zero it is only flushed when a program exits.
+	FlushInterval time.Duration
+}
+
+// ExecRunner runs programs in the manner of collectd's exec plugin, their
+// PUTVAL lines are added to a MetricSink and their PUTNOTIF lines too, if
+// the sink is a NotificationSink. The sink must be safe to use from
+// multiple goroutines concurrently.
+type ExecRunner struct {
+	sink     MetricSink
+	defaults MetricDefaults
+	types    TypesDB
+	logger   *log.Logger
+	minDelay time.Duration
+	maxDelay time.Duration
+	killWait time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewExecRunner starts the commands, restarting them
+// when they exit until the runner is closed.
+func NewExecRunner(sink MetricSink, opts ExecRunnerOptions, cmds ...ExecCommand) *ExecRunner {
+	if opts.Types == nil {
+		opts.Types = builtinTypes
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.Default()
+	}
+	if opts.MinRestartDelay <= 0 {
+		opts.MinRestartDelay = DefaultMinRestartDelay
+	}
+	if opts.MaxRestartDelay <= 0 {
+		opts.MaxRestartDelay = DefaultMaxRestartDelay
+	}
+	if opts.KillTimeout <= 0 {
+		opts.KillTimeout = DefaultKillTimeout
+	}
+	r := &ExecRunner{
+		sink:     sink,
+		defaults: opts.Defaults,
+		types:    opts.Types,
+		logger:   opts.Logger,
+		minDelay: opts.MinRestartDelay,
+		maxDelay: opts.MaxRestartDelay,
+		killWait: opts.KillTimeout,
+		stop:     make(chan struct{}),
+	}
+	for _, cmd := range cmds {
+		r.wg.Add(1)
+		go r.run(cmd)
+	}
+	if opts.FlushInterval > 0 {
+		r.wg.Add(1)
+		go r.tick(opts.FlushInterval)
+	}
+	return r
+}
+
+func (r *ExecRunner) tick(interval time.Duration) {
+	defer r.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			r.flush()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *ExecRunner) flush() {
+	if f, ok := r.sink.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			r.logger.Printf("exec: flush: %s", err)
+		}
+	}
+}
+
+func (r *ExecRunner) run(cmd ExecCommand) {
+	defer r.wg.Done()
+	delay := r.minDelay
+	for {
+		start := time.Now()
+		if err := r.runOnce(cmd); err != nil {
+			r.logger.Printf("exec %s: %s", cmd.Path, err)
+		}
+		r.flush()
+		if time.Since(start) >= r.maxDelay {
+			delay = r.minDelay
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.stop:
+			return
+		}
+		delay *= 2
+		if delay > r.maxDelay {
+			delay = r.maxDelay
+		}
+	}
+}
+
+func (r *ExecRunner) runOnce(cmd ExecCommand) error {
+	c := exec.Command(cmd.Path, cmd.Args...)
+	c.Env = os.Environ()
+	if r.defaults.Host != "" {
+		c.Env = append(c.Env, "COLLECTD_HOSTNAME="+r.defaults.Host)
+	}
+	if r.defaults.Interval > 0 {
+		c.Env = append(c.Env, "COLLECTD_INTERVAL="+strconv.FormatFloat(r.defaults.Interval.Seconds(), 'f', 3, 64))
+	}
+	c.Env = append(c.Env, cmd.Env...)
+	if err := setProcAttr(c, cmd.User, cmd.Group); err != nil {
+		return err
+	}
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := c.StderrPipe()
+	if err != nil {
+		return err
+	}
+	select {
+	case <-r.stop:
+		return nil
+	default:
+	}
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-r.stop:
+			_ = terminate(c.Process)
+			select {
+			case <-time.After(r.killWait):
+				_ = kill(c.Process)
+			case <-exited:
+			}
+		case <-exited:
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.readLines(cmd, stderr, func(line string) {
+			r.logger.Printf("exec %s: %s", cmd.Path, line)
+		})
+	}()
+	r.readLines(cmd, stdout, func(line string) {
+		if err := r.handleLine(line); err != nil {
+			r.logger.Printf("exec %s: %s", cmd.Path, err)
+		}
+	})
+	wg.Wait()
+	return c.Wait()
+}
+
+// maxExecLine is the longest line read from a program,
+// longer lines are logged and skipped.
+const maxExecLine = 64 * 1024
+
+// readLines calls fn with each line of rd until it is closed.
+func (r *ExecRunner) readLines(cmd ExecCommand, rd io.Reader, fn func(string)) {
+	br := bufio.NewReaderSize(rd, maxExecLine)
+	for {
+		line, isPrefix, err := br.ReadLine()
+		if err != nil {
+			if err != io.EOF {
+				r.logger.Printf("exec %s: %s", cmd.Path, err)
+			}
+			return
+		}
+		if isPrefix {
+			r.logger.Printf("exec %s: skipped a line longer than %d bytes", cmd.Path, maxExecLine)
+			for isPrefix && err == nil {
+				_, isPrefix, err = br.ReadLine()
+			}
+			continue
+		}
+		fn(string(line))
+	}
+}
+
+func (r *ExecRunner) handleLine(line string) error {
+	vls, n, err := parseExecLine(line, r.types, r.defaults, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, v := range vls {
+		if err := r.sink.AddValueList(v); err != nil {
+			return err
+		}
+	}
+	if n != nil {
+		ns, ok := r.sink.(NotificationSink)
+		if !ok {
+			return errors.New("notifications are not supported by the sink")
+		}
+		return ns.AddNotification(n)
+	}
+	return nil
+}
+
+// Close stops the programs, sending SIGTERM where supported and killing
+// them after KillTimeout, and waits for them to exit.
+func (r *ExecRunner) Close() error {
+	r.stopOnce.Do(func() { close(r.stop) })
+	r.wg.Wait()
+	return nil
+}
+
+// parseExecLine parses a PUTVAL or PUTNOTIF line written by a program
+// for collectd's exec plugin.
+func parseExecLine(line string, types TypesDB, defaults MetricDefaults, now time.Time) ([]ValueList, *Notification, error) {
+	fields, err := splitExecLine(line)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(fields) == 0 {
+		return nil, nil, nil
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "PUTVAL":
+		vls, err := parsePutval(fields[1:], types, defaults, now)
+		return vls, nil, err
+	case "PUTNOTIF":
+		n, err := parsePutnotif(fields[1:])
+		return nil, n, err
+	default:
+		return nil, nil, fmt.Errorf("unknown command %q", fields[0])
+	}
+}
+
+// splitExecLine splits a line at whitespace, fields and option values
+// may be quoted with '"', a '\' escapes the next character in quotes.
+func splitExecLine(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); {
+		if isExecSpace(line[i]) {
+			i++
+			continue
+		}
+		field.Reset()
+		start := i
+		for i < len(line) && !isExecSpace(line[i]) {
+			c := line[i]
+			// Quotes may open a field or an option value.
+			if c == '"' && (i == start || line[i-1] == '=' && !strings.Contains(line[start:i-1], "=")) {
+				i++
+				for i < len(line) && line[i] != '"' {
+					if line[i] == '\\' && i+1 < len(line) {
+						i++
+					}
+					field.WriteByte(line[i])
+					i++
+				}
+				if i == len(line) {
+					return nil, errors.New("unterminated string")
+				}
+				i++
+				if i < len(line) && !isExecSpace(line[i]) {
+					return nil, errors.New("garbage after closing quote")
+				}
+				continue
+			}
+			field.WriteByte(c)
+			i++
+		}
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+func isExecSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func parsePutval(fields []string, types TypesDB, defaults MetricDefaults, now time.Time) ([]ValueList, error) {
+	if len(fields) < 2 {
+		return nil, errors.New("PUTVAL needs an identifier and values")
+	}
+	m := &Metric{Interval: defaults.Interval}
+	if err := parseExecIdentifier(fields[0], m); err != nil {
+		return nil, err
+	}
+	dsTypes, ok := types[m.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown type %q", m.Type)
+	}
+	m.DSTypes = dsTypes
+	var vls []ValueList
+	for _, f := range fields[1:] {
+		if i := strings.IndexByte(f, '='); i >= 0 {
+			if len(vls) != 0 {
+				return nil, fmt.Errorf("option %q after values", f)
+			}
+			key, value := f[:i], f[i+1:]
+			if strings.ToLower(key) != "interval" {
+				return nil, fmt.Errorf("unknown option %q", key)
+			}
+			secs, err := strconv.ParseFloat(value, 64)
+			if err != nil || secs <= 0 || math.IsInf(secs, 0) {
+				return nil, fmt.Errorf("invalid interval %q", value)
+			}
+			m.Interval = time.Duration(secs * float64(time.Second))
+			continue
+		}
+		v, err := parseExecValues(f, m, now)
+		if err != nil {
+			return nil, err
+		}
+		vls = append(vls, v)
+	}
+	if len(vls) == 0 {
+		return nil, errors.New("PUTVAL has no values")
+	}
+	return vls, nil
+}
+
+// parseExecIdentifier parses host/plugin[-instance]/type[-instance].
+func parseExecIdentifier(s string, m *Metric) error {
+	parts := strings.SplitN(s, "/", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return fmt.Errorf("invalid identifier %q", s)
+	}
+	m.Host = parts[0]
+	m.Plugin, m.PluginInstance = splitInstance(parts[1])
+	m.Type, m.TypeInstance = splitInstance(parts[2])
+	return nil
+}
+
+func splitInstance(s string) (string, string) {
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// parseExecValues parses time:value[:value...], the time may be "N" for now
+// and gauges may be "U" when unknown.
+func parseExecValues(s string, m *Metric, now time.Time) (ValueList, error) {
+	parts := strings.Split(s, ":")
+	if len(parts)-1 != len(m.DSTypes) {
+		return ValueList{}, fmt.Errorf("%q has %d values, type %q has %d", s, len(parts)-1, m.Type, len(m.DSTypes))
+	}
+	v := ValueList{Metric: m, Time: now, Values: make([]float64, len(m.DSTypes))}
+	if parts[0] != "N" {
+		t, err := parseExecTime(parts[0])
+		if err != nil {
+			return ValueList{}, err
+		}
+		v.Time = t
+	}
+	for i, p := range parts[1:] {
+		var err error
+		switch m.DSTypes[i] {
+		case GAUGE:
+			if p == "U" {
+				v.Values[i] = math.NaN()
+			} else {
+				v.Values[i], err = strconv.ParseFloat(p, 64)
+			}
+		case DERIVE:
+			var n int64
+			n, err = strconv.ParseInt(p, 10, 64)
+			v.Values[i] = float64(n)
+		default:
+			var n uint64
+			n, err = strconv.ParseUint(p, 10, 64)
+			v.Values[i] = float64(n)
+		}
+		if err != nil {
+			return ValueList{}, fmt.Errorf("invalid value %q", p)
+		}
+	}
+	return v, nil
+}
+
+// parseExecTime parses seconds since the epoch, decimal fractions
+// are parsed exactly to the nanosecond.
+func parseExecTime(s string) (time.Time, error) {
+	sec, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		sec, frac = s[:i], s[i+1:]
+	}
+	if n, err := strconv.ParseInt(sec, 10, 64); err == nil && n > 0 && len(frac) <= 9 {
+		ns, err := strconv.ParseUint(frac+"000000000"[len(frac):], 10, 64)
+		if err == nil {
+			return time.Unix(n, int64(ns)), nil
+		}
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || secs <= 0 || math.IsInf(secs, 0) {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	whole, fraction := math.Modf(secs)
+	return time.Unix(int64(whole), int64(math.Round(fraction*1e9))), nil
+}
+
+func parsePutnotif(fields []string) (*Notification, error) {
+	n := &Notification{}
+	hasMessage := false
+	for _, f := range fields {
+		i := strings.IndexByte(f, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid option %q", f)
+		}
+		key, value := f[:i], f[i+1:]
+		var err error
+		switch strings.ToLower(key) {
+		case "severity":
+			n.Severity, err = ParseSeverity(value)
+		case "time":
+			n.Time, err = parseExecTime(value)
+		case "host":
+			n.Host = value
+		case "plugin":
+			n.Plugin = value
+		case "plugin_instance":
+			n.PluginInstance = value
+		case "type":
+			n.Type = value
+		case "type_instance":
+			n.TypeInstance = value
+		case "message":
+			n.Message = value
+			hasMessage = true
+		default:
+			err = fmt.Errorf("unknown option %q", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case n.Severity == 0:
+		return nil, errors.New("PUTNOTIF has no severity")
+	case n.Time.IsZero():
+		return nil, errors.New("PUTNOTIF has no time")
+	case !hasMessage:
+		return nil, errors.New("PUTNOTIF has no message")
+	}
+	return n, nil
+}
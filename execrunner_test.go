@@ -0,0 +1,299 @@
+package cdclient
+
+import (
+	"bytes"
+	"log"
+	"math"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseExecLine(t *testing.T) {
+	now := time.Unix(1426076671, 0)
+	defaults := MetricDefaults{Interval: 10 * time.Second}
+	types := TypesDB{"gauge": {GAUGE}, "if_octets": {DERIVE, DERIVE}}
+
+	vls, n, err := parseExecLine(`PUTVAL "example.com/golang-foo bar/if_octets-eth0" interval=0.5 1426076681.25:1:-2 N:3:4`, types, defaults, now)
+	if err != nil || n != nil {
+		t.Fatal(err)
+	}
+	m := &Metric{
+		Host:           "example.com",
+		Plugin:         "golang",
+		PluginInstance: "foo bar",
+		Type:           "if_octets",
+		TypeInstance:   "eth0",
+		DSTypes:        []DSType{DERIVE, DERIVE},
+		Interval:       500 * time.Millisecond,
+	}
+	expected := []ValueList{
+		{Metric: m, Time: time.Unix(1426076681, 250000000), Values: []float64{1, -2}},
+		{Metric: m, Time: now, Values: []float64{3, 4}},
+	}
+	if !reflect.DeepEqual(vls, expected) {
+		t.Fatalf("got %+v, expected %+v", vls, expected)
+	}
+
+	vls, _, err = parseExecLine("putval example.com/golang/gauge N:U", types, defaults, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vls[0].Metric.Interval != 10*time.Second || !math.IsNaN(vls[0].Values[0]) {
+		t.Fatalf("got %+v", vls[0])
+	}
+
+	vls, n, err = parseExecLine("", types, defaults, now)
+	if vls != nil || n != nil || err != nil {
+		t.Fatal("expected nothing for an empty line")
+	}
+
+	for _, line := range []string{
+		"GETVAL example.com/golang/gauge",
+		"PUTVAL example.com/golang/gauge",
+		"PUTVAL example.com/golang N:1",
+		"PUTVAL example.com/golang/unknown N:1",
+		"PUTVAL example.com/golang/gauge N:1:2",
+		"PUTVAL example.com/golang/gauge N:one",
+		"PUTVAL example.com/golang/if_octets N:U:1",
+		"PUTVAL example.com/golang/if_octets N:1.5:1",
+		"PUTVAL example.com/golang/gauge later:1",
+		"PUTVAL example.com/golang/gauge interval=0 N:1",
+		"PUTVAL example.com/golang/gauge N:1 interval=10",
+		"PUTVAL example.com/golang/gauge color=red N:1",
+		`PUTVAL "example.com/golang/gauge N:1`,
+	} {
+		if _, _, err := parseExecLine(line, types, defaults, now); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
+
+func TestParsePutnotif(t *testing.T) {
+	_, n, err := parseExecLine(`PUTNOTIF severity=warning time=1426076671.5 host=example.com plugin=golang type_instance=a message="disk \"sda\" is full"`, nil, MetricDefaults{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Notification{
+		Severity:     Warning,
+		Time:         time.Unix(1426076671, 500000000),
+		Host:         "example.com",
+		Plugin:       "golang",
+		TypeInstance: "a",
+		Message:      `disk "sda" is full`,
+	}
+	if !reflect.DeepEqual(n, expected) {
+		t.Fatalf("got %+v, expected %+v", n, expected)
+	}
+
+	for _, line := range []string{
+		"PUTNOTIF time=1426076671 message=hi",
+		"PUTNOTIF severity=bad time=1426076671 message=hi",
+		"PUTNOTIF severity=okay message=hi",
+		"PUTNOTIF severity=okay time=1426076671",
+		"PUTNOTIF severity=okay time=1426076671 message=hi color=red",
+	} {
+		if _, _, err := parseExecLine(line, nil, MetricDefaults{}, time.Now()); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
+
+// recordingSink records value lists and notifications.
+type recordingSink struct {
+	lock          sync.Mutex
+	valueLists    []ValueList
+	notifications []*Notification
+}
+
+func (s *recordingSink) AddValues(m *Metric, t time.Time, values ...float64) error {
+	return s.AddValueList(ValueList{Metric: m, Time: t, Values: append([]float64(nil), values...)})
+}
+
+func (s *recordingSink) AddValueList(v ValueList) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.valueLists = append(s.valueLists, v)
+	return nil
+}
+
+func (s *recordingSink) AddNotification(n *Notification) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.notifications = append(s.notifications, n)
+	return nil
+}
+
+func (s *recordingSink) counts() (int, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.valueLists), len(s.notifications)
+}
+
+// lockedWriter is an io.Writer safe for use from several goroutines.
+type lockedWriter struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *lockedWriter) String() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.buf.String()
+}
+
+func TestExecRunner(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip(err)
+	}
+	script := `
+echo "PUTVAL $COLLECTD_HOSTNAME/golang/gauge interval=$COLLECTD_INTERVAL N:$EXTRA"
+echo "PUTNOTIF severity=okay time=1426076671 message=started"
+echo "PUTVAL bad N:1"
+echo "oops" >&2
+sleep 60
+`
+	sink := &recordingSink{}
+	logs := &lockedWriter{}
+	r := NewExecRunner(sink, ExecRunnerOptions{
+		Defaults: MetricDefaults{Host: "example.com", Interval: 2500 * time.Millisecond},
+		Logger:   log.New(logs, "", 0),
+	}, ExecCommand{Path: sh, Args: []string{"-c", script}, Env: []string{"EXTRA=7"}})
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		vls, notifs := sink.counts()
+		if vls == 1 && notifs == 1 && strings.Contains(logs.String(), "oops") &&
+			strings.Contains(logs.String(), "invalid identifier") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d value lists, %d notifications and logs %q", vls, notifs, logs.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		_ = r.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the program was not stopped")
+	}
+
+	v := sink.valueLists[0]
+	if v.Metric.Host != "example.com" || v.Metric.Interval != 2500*time.Millisecond || v.Values[0] != 7 {
+		t.Fatalf("got %+v %+v", v.Metric, v)
+	}
+	if sink.notifications[0].Message != "started" {
+		t.Fatalf("got %+v", sink.notifications[0])
+	}
+}
+
+func TestExecRunnerRestart(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip(err)
+	}
+	sink := &recordingSink{}
+	r := NewExecRunner(sink, ExecRunnerOptions{
+		Logger:          log.New(&lockedWriter{}, "", 0),
+		MinRestartDelay: time.Millisecond,
+		MaxRestartDelay: 10 * time.Millisecond,
+	}, ExecCommand{Path: sh, Args: []string{"-c", "echo PUTVAL example.com/golang/gauge N:1"}})
+	defer r.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if vls, _ := sink.counts(); vls >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the program was not restarted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestExecRunnerLongLine(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip(err)
+	}
+	script := `
+head -c 70000 /dev/zero | tr '\0' x
+echo
+echo PUTVAL example.com/golang/gauge N:1
+sleep 60
+`
+	sink := &recordingSink{}
+	logs := &lockedWriter{}
+	r := NewExecRunner(sink, ExecRunnerOptions{
+		Logger: log.New(logs, "", 0),
+	}, ExecCommand{Path: sh, Args: []string{"-c", script}})
+	defer r.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if vls, _ := sink.counts(); vls == 1 && strings.Contains(logs.String(), "skipped a line") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the line after the long line was not read, logs %q", logs.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExecRunnerKill(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip(err)
+	}
+	script := `
+trap "" TERM
+echo PUTVAL example.com/golang/gauge N:1
+sleep 60
+`
+	sink := &recordingSink{}
+	r := NewExecRunner(sink, ExecRunnerOptions{
+		Logger:      log.New(&lockedWriter{}, "", 0),
+		KillTimeout: 10 * time.Millisecond,
+	}, ExecCommand{Path: sh, Args: []string{"-c", script}})
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if vls, _ := sink.counts(); vls == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the program did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		_ = r.Close()
+		_ = r.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the program was not killed")
+	}
+}
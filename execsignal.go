@@ -0,0 +1,23 @@
+//go:build !plan9 && !js
+// +build !plan9,!js
+
+package cdclient
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// execSignals are handled by an ExecClient with ExitOnClose. Once SIGPIPE
+// is handled, writes to a closed stdout return EPIPE instead of killing
+// the process.
+var execSignals = []os.Signal{syscall.SIGPIPE, syscall.SIGTERM}
+
+func isTermSignal(sig os.Signal) bool {
+	return sig == syscall.SIGTERM
+}
+
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,17 @@
+//go:build plan9 || js
+// +build plan9 js
+
+package cdclient
+
+import "os"
+
+// execSignals is empty, these platforms have no SIGPIPE or SIGTERM.
+var execSignals []os.Signal
+
+func isTermSignal(sig os.Signal) bool {
+	return false
+}
+
+func isBrokenPipe(err error) bool {
+	return false
+}
@@ -0,0 +1,41 @@
+//go:build !plan9 && !js
+// +build !plan9,!js
+
+package cdclient
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type epipeWriter struct{}
+
+func (epipeWriter) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: "/dev/stdout", Err: syscall.EPIPE}
+}
+
+func TestExecClientExitOnClose(t *testing.T) {
+	c := NewExecClient(ExecClientOptions{Writer: epipeWriter{}, ExitOnClose: true})
+	exited := make(chan int, 2)
+	c.exit = func(code int) { exited <- code }
+	_ = c.AddValues(testMetric(), time.Unix(1426076671, 0), 1)
+	_ = c.Flush()
+	if code := <-exited; code != 0 {
+		t.Fatalf("exit code %d, want 0", code)
+	}
+
+	w := &lockedBuffer{}
+	c = NewExecClient(ExecClientOptions{Writer: w, ExitOnClose: true})
+	c.exit = func(code int) { exited <- code }
+	_ = c.AddValues(testMetric(), time.Unix(1426076671, 0), 1)
+	c.signals <- syscall.SIGTERM
+	if code := <-exited; code != 0 {
+		t.Fatalf("exit code %d, want 0", code)
+	}
+	if w.String() == "" {
+		t.Fatal("values were not flushed before exiting")
+	}
+	_ = c.Close()
+}
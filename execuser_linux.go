@@ -0,0 +1,50 @@
+package cdclient
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// setProcAttr runs the program in its own process group, as another user
+// when username is set.
+func setProcAttr(c *exec.Cmd, username, group string) error {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if username == "" {
+		return nil
+	}
+	u, err := user.Lookup(username)
+	if err != nil {
+		return err
+	}
+	gid := u.Gid
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return err
+		}
+		gid = g.Gid
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return err
+	}
+	g, err := strconv.ParseUint(gid, 10, 32)
+	if err != nil {
+		return err
+	}
+	c.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(g)}
+	return nil
+}
+
+// terminate asks the process group to exit, so programs
+// started by a shell are stopped too.
+func terminate(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGTERM)
+}
+
+func kill(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package cdclient
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func setProcAttr(c *exec.Cmd, username, group string) error {
+	if username != "" {
+		return errors.New("running programs as another user is only supported on linux")
+	}
+	return nil
+}
+
+func terminate(p *os.Process) error {
+	return p.Kill()
+}
+
+func kill(p *os.Process) error {
+	return p.Kill()
+}
@@ -0,0 +1,30 @@
+package cdclient
+
+// mtuBufferSize returns the largest udp payload that fits in a
+// single IP packet with the given MTU, within the size limits
+// of the protocol.
+func mtuBufferSize(mtu int, ipv4 bool) int {
+	const udpHeader = 8
+	var size int
+	if ipv4 {
+		// The IPv4 total length includes its 20 byte header.
+		if mtu > 65535 {
+			mtu = 65535
+		}
+		size = mtu - 20 - udpHeader
+	} else {
+		// The IPv6 payload length excludes its 40 byte header.
+		size = mtu - 40
+		if size > 65535 {
+			size = 65535
+		}
+		size -= udpHeader
+	}
+	if size > MaxBufferSize {
+		size = MaxBufferSize
+	}
+	if size < MinimumBufferSize {
+		size = MinimumBufferSize
+	}
+	return size
+}
@@ -0,0 +1,36 @@
+package cdclient
+
+import (
+	"net"
+	"syscall"
+)
+
+// pathMTUBufferSize returns the largest udp payload that fits in the
+// route MTU of a connected socket.
+func pathMTUBufferSize(conn Transport) (int, bool) {
+	uc, ok := conn.(*net.UDPConn)
+	if !ok {
+		return 0, false
+	}
+	raddr, ok := uc.RemoteAddr().(*net.UDPAddr)
+	if !ok {
+		return 0, false
+	}
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	ipv4 := raddr.IP.To4() != nil
+	var mtu int
+	cerr := rc.Control(func(fd uintptr) {
+		if ipv4 {
+			mtu, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU)
+		} else {
+			mtu, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU)
+		}
+	})
+	if cerr != nil || err != nil {
+		return 0, false
+	}
+	return mtuBufferSize(mtu, ipv4), true
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package cdclient
+
+func pathMTUBufferSize(conn Transport) (int, bool) {
+	return 0, false
+}
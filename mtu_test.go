@@ -0,0 +1,50 @@
+package cdclient
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestMTUBufferSize(t *testing.T) {
+	for _, tc := range []struct {
+		mtu  int
+		ipv4 bool
+		want int
+	}{
+		{1500, true, 1472},
+		{1500, false, DefaultBufferSize},
+		{9000, true, 8972},
+		{65536, true, 65507},
+		{65536, false, 65488},
+		{100, true, MinimumBufferSize},
+	} {
+		if got := mtuBufferSize(tc.mtu, tc.ipv4); got != tc.want {
+			t.Errorf("mtu %d ipv4 %v got %d, want %d", tc.mtu, tc.ipv4, got, tc.want)
+		}
+	}
+}
+
+func TestUDPClientPathMTU(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("path mtu is only supported on linux")
+	}
+	c, err := DialUDP("127.0.0.1:25826", UDPClientOptions{PathMTU: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	size, ok := pathMTUBufferSize(c.conn)
+	if !ok {
+		t.Fatal("unable to read the path mtu")
+	}
+	if got := c.packet.(*PlainTextPacket).size; got != size {
+		t.Fatalf("got buffer size %d, want %d", got, size)
+	}
+}
+
+func TestUDPClientBufferSizeLimit(t *testing.T) {
+	_, err := NewUDPClient(&MemoryTransport{}, UDPClientOptions{BufferSize: MaxBufferSize + 1})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,289 @@
+package cdclient
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerConfig is a <Server> block of the collectd network plugin.
+type ServerConfig struct {
+	// Address is the "host:port" of the server.
+	Address string
+	Options UDPClientOptions
+}
+
+// LoadNetworkConfig reads the network plugin servers from a collectd
+// config file, see ParseNetworkConfig.
+func LoadNetworkConfig(path string) ([]ServerConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseNetworkConfig(f)
+}
+
+// ParseNetworkConfig reads the <Server> blocks of every <Plugin network>
+// block of a collectd config file, for example:
+//
+//   <Plugin network>
+//     TimeToLive 128
+//     <Server "collectd.example.com" "25826">
+//       SecurityLevel Encrypt
+//       Username "alice"
+//       Password "w0nderl4nd"
+//     </Server>
+//   </Plugin>
+//
+// The supported server options are SecurityLevel, Username, Password,
+// Interface and ResolveInterval, and the supported plugin options are
+// TimeToLive, MaxPacketSize and Interface, which servers can override.
+// Other options and blocks are ignored.
+func ParseNetworkConfig(r io.Reader) ([]ServerConfig, error) {
+	root, err := parseConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	var servers []ServerConfig
+	for _, plugin := range root.children {
+		if !strings.EqualFold(plugin.key, "Plugin") || !plugin.block ||
+			len(plugin.values) != 1 || !strings.EqualFold(plugin.values[0], "network") {
+			continue
+		}
+		var pluginOpts UDPClientOptions
+		for _, n := range plugin.children {
+			var err error
+			switch strings.ToLower(n.key) {
+			case "timetolive":
+				pluginOpts.TTL, err = n.int()
+			case "maxpacketsize":
+				pluginOpts.BufferSize, err = n.int()
+			case "interface":
+				pluginOpts.Interface, err = n.string()
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, n := range plugin.children {
+			if !strings.EqualFold(n.key, "Server") || !n.block {
+				continue
+			}
+			s, err := parseServerConfig(n, pluginOpts)
+			if err != nil {
+				return nil, err
+			}
+			servers = append(servers, s)
+		}
+	}
+	return servers, nil
+}
+
+func parseServerConfig(n *configNode, opts UDPClientOptions) (ServerConfig, error) {
+	if len(n.values) < 1 || len(n.values) > 2 {
+		return ServerConfig{}, n.errorf("<Server> takes a host and an optional port")
+	}
+	port := DefaultPort
+	if len(n.values) == 2 {
+		port = n.values[1]
+	}
+	s := ServerConfig{Address: net.JoinHostPort(n.values[0], port)}
+	for _, c := range n.children {
+		var err error
+		switch strings.ToLower(c.key) {
+		case "securitylevel":
+			var level string
+			level, err = c.string()
+			switch strings.ToLower(level) {
+			case "none":
+				opts.Mode = UDPPlainText
+			case "sign":
+				opts.Mode = UDPSign
+			case "encrypt":
+				opts.Mode = UDPEncrypt
+			default:
+				if err == nil {
+					err = c.errorf("unknown SecurityLevel %q", level)
+				}
+			}
+		case "username":
+			opts.Username, err = c.string()
+		case "password":
+			opts.Password, err = c.string()
+		case "interface":
+			opts.Interface, err = c.string()
+		case "resolveinterval":
+			var seconds float64
+			seconds, err = c.float()
+			opts.ResolveInterval = time.Duration(seconds * float64(time.Second))
+		}
+		if err != nil {
+			return ServerConfig{}, err
+		}
+	}
+	s.Options = opts
+	return s, nil
+}
+
+// configNode is an option or block of a collectd config file.
+type configNode struct {
+	line     int
+	key      string
+	values   []string
+	block    bool
+	children []*configNode
+}
+
+func (n *configNode) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s: %s", n.line, n.key, fmt.Sprintf(format, args...))
+}
+
+func (n *configNode) string() (string, error) {
+	if len(n.values) != 1 {
+		return "", n.errorf("expected a single value")
+	}
+	return n.values[0], nil
+}
+
+func (n *configNode) float() (float64, error) {
+	s, err := n.string()
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, n.errorf("expected a number")
+	}
+	return f, nil
+}
+
+func (n *configNode) int() (int, error) {
+	s, err := n.string()
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, n.errorf("expected an integer")
+	}
+	return i, nil
+}
+
+type configToken struct {
+	s      string
+	quoted bool
+}
+
+// parseConfig parses the collectd config syntax into a tree of nodes.
+func parseConfig(r io.Reader) (*configNode, error) {
+	root := &configNode{block: true}
+	stack := []*configNode{root}
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+		start := lineno
+		// A trailing backslash continues the line.
+		for strings.HasSuffix(line, "\\") && scanner.Scan() {
+			lineno++
+			line = line[:len(line)-1] + scanner.Text()
+		}
+		tokens, err := tokenizeConfigLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", start, err)
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+		first := tokens[0]
+		parent := stack[len(stack)-1]
+		switch {
+		case !first.quoted && strings.HasPrefix(first.s, "</"):
+			key := strings.TrimSuffix(first.s[2:], ">")
+			if len(stack) == 1 || !strings.EqualFold(key, parent.key) {
+				return nil, fmt.Errorf("line %d: unexpected </%s>", start, key)
+			}
+			stack = stack[:len(stack)-1]
+		case !first.quoted && strings.HasPrefix(first.s, "<"):
+			last := &tokens[len(tokens)-1]
+			if last.quoted || !strings.HasSuffix(last.s, ">") {
+				return nil, fmt.Errorf("line %d: block is missing '>'", start)
+			}
+			last.s = last.s[:len(last.s)-1]
+			if last.s == "" && len(tokens) > 1 {
+				tokens = tokens[:len(tokens)-1]
+			}
+			tokens[0].s = tokens[0].s[1:]
+			n := newConfigNode(start, tokens)
+			n.block = true
+			parent.children = append(parent.children, n)
+			stack = append(stack, n)
+		default:
+			parent.children = append(parent.children, newConfigNode(start, tokens))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("<%s> on line %d is not closed", stack[len(stack)-1].key, stack[len(stack)-1].line)
+	}
+	return root, nil
+}
+
+func newConfigNode(line int, tokens []configToken) *configNode {
+	n := &configNode{line: line, key: tokens[0].s}
+	for _, t := range tokens[1:] {
+		n.values = append(n.values, t.s)
+	}
+	return n
+}
+
+func tokenizeConfigLine(line string) ([]configToken, error) {
+	var tokens []configToken
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			return tokens, nil
+		case c == '"':
+			var sb strings.Builder
+			i++
+			for {
+				if i >= len(line) {
+					return nil, errors.New("unterminated string")
+				}
+				c := line[i]
+				if c == '"' {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(line) {
+					i++
+					c = line[i]
+				}
+				sb.WriteByte(c)
+				i++
+			}
+			tokens = append(tokens, configToken{s: sb.String(), quoted: true})
+		default:
+			j := i
+			for j < len(line) && line[j] != ' ' && line[j] != '\t' && line[j] != '\r' {
+				j++
+			}
+			tokens = append(tokens, configToken{s: line[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,109 @@
+package cdclient
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCollectdConf = `# collectd.conf
+Hostname "example.com"
+LoadPlugin network
+
+<Plugin cpu>
+  ReportByCpu true
+</Plugin>
+
+<Plugin "network">
+  TimeToLive 64 # hops
+  MaxPacketSize 1024
+  Interface eth1
+  <Server "collectd.example.com" "25827">
+    SecurityLevel Encrypt
+    Username "alice"
+    Password "w0nder \"l4nd\""
+    Interface eth0
+    ResolveInterval 0.5
+  </Server>
+  <Server "ff18::efc0:4a42">
+    SecurityLevel "sign"
+    Username bob
+    Password \
+      "bu1|der"
+  </Server>
+  <Listen "0.0.0.0">
+    SecurityLevel None
+  </Listen>
+</Plugin>
+`
+
+func TestParseNetworkConfig(t *testing.T) {
+	got, err := ParseNetworkConfig(strings.NewReader(testCollectdConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ServerConfig{
+		{
+			Address: "collectd.example.com:25827",
+			Options: UDPClientOptions{
+				Mode:            UDPEncrypt,
+				Username:        "alice",
+				Password:        `w0nder "l4nd"`,
+				BufferSize:      1024,
+				TTL:             64,
+				Interface:       "eth0",
+				ResolveInterval: 500 * time.Millisecond,
+			},
+		},
+		{
+			Address: "[ff18::efc0:4a42]:25826",
+			Options: UDPClientOptions{
+				Mode:       UDPSign,
+				Username:   "bob",
+				Password:   "bu1|der",
+				BufferSize: 1024,
+				TTL:        64,
+				Interface:  "eth1",
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseNetworkConfigErrors(t *testing.T) {
+	for _, conf := range []string{
+		"<Plugin network>\n",
+		"</Plugin>\n",
+		"<Plugin network\n</Plugin>\n",
+		"Hostname \"example.com\n",
+		"<Plugin network>\n<Server a>\nSecurityLevel Secret\n</Server>\n</Plugin>\n",
+		"<Plugin network>\nTimeToLive many\n</Plugin>\n",
+	} {
+		if _, err := ParseNetworkConfig(strings.NewReader(conf)); err == nil {
+			t.Errorf("expected error for %q", conf)
+		}
+	}
+}
+
+func TestLoadNetworkConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collectd.conf")
+	if err := os.WriteFile(path, []byte(testCollectdConf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadNetworkConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := ParseNetworkConfig(strings.NewReader(testCollectdConf))
+	if len(got) != 2 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if _, err := LoadNetworkConfig(filepath.Join(t.TempDir(), "missing.conf")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,168 @@
+package cdclient
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Severity is the severity of a notification, the values
+// match collectd's NOTIF_FAILURE, NOTIF_WARNING and NOTIF_OKAY.
+type Severity byte
+
+const (
+	Failure Severity = 1
+	Warning Severity = 2
+	Okay    Severity = 4
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Failure:
+		return "FAILURE"
+	case Warning:
+		return "WARNING"
+	case Okay:
+		return "OKAY"
+	default:
+		return fmt.Sprintf("Severity(%d)", s)
+	}
+}
+
+// ParseSeverity parses "failure", "warning" or "okay", ignoring case.
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(s) {
+	case "failure":
+		return Failure, nil
+	case "warning":
+		return Warning, nil
+	case "okay":
+		return Okay, nil
+	default:
+		return 0, fmt.Errorf("unknown severity %q", s)
+	}
+}
+
+// Notification is a collectd notification, the identifier
+// fields are optional.
+type Notification struct {
+	Severity       Severity
+	Time           time.Time
+	Host           string
+	Plugin         string
+	PluginInstance string
+	Type           string
+	TypeInstance   string
+	Message        string
+	// Meta holds extra fields, such as those set by the threshold plugin.
+	Meta map[string]string
+}
+
+// NotificationSink is implemented by clients that can send notifications.
+type NotificationSink interface {
+	AddNotification(*Notification) error
+}
+
+// ParseNotification reads a notification in the format collectd's exec
+// plugin writes to NotificationExec programs, for example:
+//
+//	Severity: FAILURE
+//	Time: 1426076671.123
+//	Host: example.com
+//	Plugin: cpu
+//	DataSource: value
+//
+//	Host example.com, plugin cpu has failed.
+//
+// Headers other than Severity, Time, Host, Plugin, PluginInstance, Type
+// and TypeInstance are stored in Meta. The message follows a blank line.
+func ParseNotification(r io.Reader) (*Notification, error) {
+	n := &Notification{}
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if err == io.EOF && line == "" {
+			return nil, errors.New("notification has no message")
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid header %q", line)
+		}
+		key, value := line[:i], strings.TrimSpace(line[i+1:])
+		err = nil
+		switch strings.ToLower(key) {
+		case "severity":
+			n.Severity, err = ParseSeverity(value)
+		case "time":
+			n.Time, err = parseExecTime(value)
+		case "host":
+			n.Host = value
+		case "plugin":
+			n.Plugin = value
+		case "plugininstance":
+			n.PluginInstance = value
+		case "type":
+			n.Type = value
+		case "typeinstance":
+			n.TypeInstance = value
+		default:
+			if n.Meta == nil {
+				n.Meta = make(map[string]string)
+			}
+			n.Meta[key] = value
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if n.Severity == 0 {
+		return nil, errors.New("notification has no severity")
+	}
+	message, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	n.Message = strings.TrimRight(string(message), "\r\n")
+	return n, nil
+}
+
+// WriteNotification writes n in the format read by ParseNotification,
+// empty fields are left out and Meta is sorted by key.
+func WriteNotification(w io.Writer, n *Notification) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Severity: %s\n", n.Severity)
+	fmt.Fprintf(&b, "Time: %.3f\n", float64(n.Time.UnixNano())/1e9)
+	for _, h := range [...]struct{ key, value string }{
+		{"Host", n.Host},
+		{"Plugin", n.Plugin},
+		{"PluginInstance", n.PluginInstance},
+		{"Type", n.Type},
+		{"TypeInstance", n.TypeInstance},
+	} {
+		if h.value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", h.key, h.value)
+		}
+	}
+	keys := make([]string, 0, len(n.Meta))
+	for k := range n.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s: %s\n", k, n.Meta[k])
+	}
+	fmt.Fprintf(&b, "\n%s\n", n.Message)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,86 @@
+package cdclient
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNotification(t *testing.T) {
+	n, err := ParseNotification(strings.NewReader(`Severity: FAILURE
+Time: 1426076671.123
+Host: example.com
+Plugin: cpu
+PluginInstance: 0
+Type: percent
+TypeInstance: idle
+DataSource: value
+CurrentValue: 2.000000e+00
+
+Host example.com, plugin cpu (instance 0) type percent (instance idle): Data source "value" is currently 2.000000.
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Notification{
+		Severity:       Failure,
+		Time:           time.Unix(1426076671, 123000000),
+		Host:           "example.com",
+		Plugin:         "cpu",
+		PluginInstance: "0",
+		Type:           "percent",
+		TypeInstance:   "idle",
+		Message:        `Host example.com, plugin cpu (instance 0) type percent (instance idle): Data source "value" is currently 2.000000.`,
+		Meta: map[string]string{
+			"DataSource":   "value",
+			"CurrentValue": "2.000000e+00",
+		},
+	}
+	if !reflect.DeepEqual(n, expected) {
+		t.Fatalf("got %+v, expected %+v", n, expected)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteNotification(&buf, n); err != nil {
+		t.Fatal(err)
+	}
+	n2, err := ParseNotification(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(n2, n) {
+		t.Fatalf("got %+v, expected %+v", n2, n)
+	}
+
+	for _, s := range []string{
+		"Time: 1426076671\n\nmessage\n",
+		"Severity: BAD\nTime: 1426076671\n\nmessage\n",
+		"Severity: OKAY\nTime: soon\n\nmessage\n",
+		"Severity: OKAY\nno colon\n\nmessage\n",
+		"Severity: OKAY\nHost: example.com",
+	} {
+		if _, err := ParseNotification(strings.NewReader(s)); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestFormatNotification(t *testing.T) {
+	var buf bytes.Buffer
+	n := &Notification{
+		Severity: Okay,
+		Time:     time.Unix(1426076671, 500000000),
+		Host:     "example.com",
+		Message:  "recovered",
+		Meta:     map[string]string{"b": "2", "a": "1"},
+	}
+	if err := WriteNotification(&buf, n); err != nil {
+		t.Fatal(err)
+	}
+	expected := "Severity: OKAY\nTime: 1426076671.500\nHost: example.com\na: 1\nb: 2\n\nrecovered\n"
+	if buf.String() != expected {
+		t.Fatalf("%q != (expected)%q", buf.String(), expected)
+	}
+}
@@ -0,0 +1,115 @@
+package cdclient
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// PacketTemplate is a set of value lists encoded once, whose values and
+// time can then be updated in place and written into a packet without
+// encoding the identifiers again. It suits metrics sent every interval
+// with the same identifiers, such as heartbeats.
+type PacketTemplate struct {
+	p       PlainTextPacket
+	timePos int
+	lists   []templateList
+}
+
+type templateList struct {
+	pos     int
+	dsTypes []DSType
+}
+
+// NewPacketTemplate encodes the value lists, which must all
+// have the same time, into a template.
+func NewPacketTemplate(vls ...ValueList) (*PacketTemplate, error) {
+	if len(vls) == 0 {
+		return nil, errors.New("template has no value lists")
+	}
+	t := &PacketTemplate{}
+	// The buffer grows as needed, only its size is limited.
+	t.p.size = MaxBufferSize
+	for _, v := range vls {
+		if !v.Time.Equal(vls[0].Time) {
+			return nil, errors.New("template value lists must have the same time")
+		}
+		if len(v.Values) != len(v.Metric.DSTypes) {
+			return nil, errors.New("value count does not match the metric")
+		}
+		if err := t.p.AddValueList(v); err != nil {
+			return nil, err
+		}
+		t.lists = append(t.lists, templateList{
+			pos:     t.p.buffer.Len() - 8*len(v.Values),
+			dsTypes: v.Metric.DSTypes,
+		})
+	}
+	t.timePos = -1
+	body := t.p.body()
+	for pos := 0; pos+4 <= len(body); {
+		typ := binary.BigEndian.Uint16(body[pos : pos+2])
+		size := int(binary.BigEndian.Uint16(body[pos+2 : pos+4]))
+		if typ == typeTimeHR {
+			t.timePos = pos + 4
+			break
+		}
+		pos += size
+	}
+	if t.timePos < 0 {
+		return nil, errors.New("template has no time")
+	}
+	return t, nil
+}
+
+// SetValue sets value j of value list i.
+func (t *PacketTemplate) SetValue(i, j int, v float64) {
+	l := t.lists[i]
+	putValue(t.p.buffer.Bytes()[l.pos+8*j:], l.dsTypes[j], v)
+}
+
+// SetTime sets the time of all value lists.
+func (t *PacketTemplate) SetTime(tm time.Time) {
+	binary.BigEndian.PutUint64(t.p.buffer.Bytes()[t.timePos:], cdtimeFromNano(uint64(tm.UnixNano())))
+	t.p.stateTime = tm
+}
+
+// Len is the size of the encoded value lists.
+func (t *PacketTemplate) Len() int {
+	return t.p.buffer.Len()
+}
+
+// Encode writes the template into p, which must be an empty PlainTextPacket,
+// SignedPacket or EncryptedPacket. p is then finalized as usual, more metrics
+// may be added to p before it is.
+func (t *PacketTemplate) Encode(p Packet) error {
+	pt, ok := p.(interface{ plainText() *PlainTextPacket })
+	if !ok {
+		return errors.New("packet does not support templates")
+	}
+	return pt.plainText().writeTemplate(t)
+}
+
+func (b *PlainTextPacket) plainText() *PlainTextPacket {
+	return b
+}
+
+func (b *PlainTextPacket) writeTemplate(t *PacketTemplate) error {
+	if b.Len() != 0 {
+		return errors.New("packet is not empty")
+	}
+	if t.Len() > b.size {
+		return ErrPacketFull
+	}
+	b.buffer.Write(t.p.body())
+	b.count = t.p.count
+	// Later value lists are encoded relative to the end of the template.
+	b.stateHost = t.p.stateHost
+	b.statePlugin = t.p.statePlugin
+	b.statePluginInstance = t.p.statePluginInstance
+	b.stateType = t.p.stateType
+	b.stateTypeInstance = t.p.stateTypeInstance
+	b.stateInterval = t.p.stateInterval
+	b.stateTime = t.p.stateTime
+	return nil
+}
@@ -0,0 +1,130 @@
+package cdclient
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func templateValueLists(t time.Time) []ValueList {
+	m1 := testMetric()
+	m2 := testMetric()
+	m2.PluginInstance = "test"
+	m2.Type = "derive"
+	m2.DSTypes = []DSType{DERIVE, COUNTER}
+	return []ValueList{
+		{Metric: m1, Time: t, Values: []float64{1}},
+		{Metric: m2, Time: t, Values: []float64{2, 3}},
+	}
+}
+
+func TestPacketTemplate(t *testing.T) {
+	t1 := time.Unix(1426076671, 123000000)
+	t2 := time.Unix(1426076681, 234000000)
+	tmpl, err := NewPacketTemplate(templateValueLists(t1)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := cap(tmpl.p.buffer.Bytes()); n > DefaultBufferSize {
+		t.Fatalf("template buffer has a capacity of %d bytes", n)
+	}
+
+	vls := templateValueLists(t2)
+	vls[0].Values[0] = 4
+	vls[1].Values[1] = 5
+	want := NewPlainTextPacket()
+	for _, v := range vls {
+		_ = want.AddValueList(v)
+	}
+
+	tmpl.SetTime(t2)
+	tmpl.SetValue(0, 0, 4)
+	tmpl.SetValue(1, 1, 5)
+	got := NewPlainTextPacket()
+	if err := tmpl.Encode(got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Finalize(), want.Finalize()) {
+		t.Fatalf("got %v, want %v", got.Finalize(), want.Finalize())
+	}
+	if got.ValueListCount() != 2 {
+		t.Fatalf("got %d value lists, want 2", got.ValueListCount())
+	}
+
+	// Metrics added after the template are encoded relative to it.
+	_ = want.AddValueList(vls[1])
+	_ = got.AddValueList(vls[1])
+	if !bytes.Equal(got.Finalize(), want.Finalize()) {
+		t.Fatalf("got %v, want %v", got.Finalize(), want.Finalize())
+	}
+
+	if err := tmpl.Encode(got); err == nil {
+		t.Fatal("expected an error for a non empty packet")
+	}
+}
+
+func TestPacketTemplateMixedTimes(t *testing.T) {
+	vls := templateValueLists(time.Unix(1426076671, 0))
+	vls[1].Time = time.Unix(1426076681, 0)
+	if _, err := NewPacketTemplate(vls...); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestPacketTemplateSigned(t *testing.T) {
+	tmpl, err := NewPacketTemplate(templateValueLists(time.Unix(1426076671, 0))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewSignedPacket("username", "password")
+	for i := 0; i < 2; i++ {
+		tmpl.SetValue(0, 0, float64(i))
+		if err := tmpl.Encode(b); err != nil {
+			t.Fatal(err)
+		}
+		if !checkSignature(b.Finalize(), "username", "password") {
+			t.Fatal("bad signature")
+		}
+		b.Reset()
+	}
+}
+
+func TestUDPClientSendTemplate(t *testing.T) {
+	tr := &MemoryTransport{}
+	c, err := NewUDPClient(tr, UDPClientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1426076671, 0)
+	tmpl, err := NewPacketTemplate(templateValueLists(now)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddValues(testMetric(), now, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendTemplate(tmpl); err != nil {
+		t.Fatal(err)
+	}
+	got := tr.Packets()
+	if len(got) != 2 {
+		t.Fatalf("got %d packets, want 2", len(got))
+	}
+	if countValueLists(got[1]) != 2 {
+		t.Fatalf("got %d value lists, want 2", countValueLists(got[1]))
+	}
+}
+
+func BenchmarkPacketTemplateEncrypted(bench *testing.B) {
+	tmpl, _ := NewPacketTemplate(templateValueLists(time.Unix(1426076671, 0))...)
+	b, _ := NewEncryptedPacket("username", "password")
+	now := time.Now()
+	bench.ReportAllocs()
+	for n := 0; n < bench.N; n++ {
+		tmpl.SetTime(now)
+		tmpl.SetValue(0, 0, float64(n))
+		_ = tmpl.Encode(b)
+		b.Finalize()
+		b.Reset()
+	}
+}
@@ -3,6 +3,7 @@ package cdclient
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"math"
 	"time"
 )
@@ -13,6 +14,10 @@ import (
 const DefaultBufferSize = 1452
 const MinimumBufferSize = 256
 
+// MaxBufferSize is the largest packet size, limited by the
+// uint16 part lengths of the encrypted and signed formats.
+const MaxBufferSize = 65535
+
 // IDs of the various "parts", i.e. subcomponents of a packet.
 const (
 	typeHost           = 0x0000
@@ -25,6 +30,8 @@ const (
 	typeValues         = 0x0006
 	typeInterval       = 0x0007
 	typeIntervalHR     = 0x0009
+	typeMessage        = 0x0100
+	typeSeverity       = 0x0101
 	typeSignSHA256     = 0x0200
 	typeEncryptAES256  = 0x0210
 )
@@ -32,7 +39,14 @@ const (
 type PlainTextPacket struct {
 	buffer    bytes.Buffer
 	tmpValues []float64
-	size      int
+	// header is reserved at the start of buffer, so signed and encrypted
+	// packets can be finalized in place without copying the metrics.
+	header []byte
+	size   int
+	count  int
+	// sealed is set once the metrics have been encrypted in place,
+	// EncryptedPacket decrypts them again before adding more.
+	sealed bool
 	// Encoding is stateful, these are last seen values.
 	stateHost           string
 	statePlugin         string
@@ -47,28 +61,58 @@ func NewPlainTextPacket() *PlainTextPacket {
 	return NewPlainTextPacketSize(DefaultBufferSize)
 }
 
-// NewBuffer initializes a new metric buffer,
-// panics if the size is smaller than MinimumBufferSize.
+// NewBuffer initializes a new metric buffer, panics if the size
+// is smaller than MinimumBufferSize or larger than MaxBufferSize.
 func NewPlainTextPacketSize(size int) *PlainTextPacket {
+	if size > MaxBufferSize {
+		panic("buffer size too large")
+	}
 	b := &PlainTextPacket{}
-	b.init(size)
+	b.init(size, nil)
 	return b
 }
 
-func (b *PlainTextPacket) init(size int) {
+// init sets the space for metrics and the header reserved before them.
+func (b *PlainTextPacket) init(size int, header []byte) {
 	if size < MinimumBufferSize {
 		panic("buffer size to small")
 	}
 	b.size = size
-	b.buffer.Grow(size)
+	b.header = header
+	b.buffer.Grow(len(header) + size)
 	b.Reset()
 }
 
+// body returns the encoded metrics.
+func (b *PlainTextPacket) body() []byte {
+	return b.buffer.Bytes()[len(b.header):]
+}
+
 func (b *PlainTextPacket) available() int {
-	if b.size < b.buffer.Len() {
+	n := b.buffer.Len() - len(b.header)
+	if b.size < n {
 		return 0
 	}
-	return b.size - b.buffer.Len()
+	return b.size - n
+}
+
+// setHeader changes the reserved header and the space for metrics, it
+// returns ErrPacketFull if buffered metrics would not fit.
+func (b *PlainTextPacket) setHeader(header []byte, size int) error {
+	if size < MinimumBufferSize {
+		return errors.New("username too long for the buffer size")
+	}
+	body := b.body()
+	if size < len(body) {
+		return ErrPacketFull
+	}
+	body = append([]byte(nil), body...)
+	b.header = header
+	b.size = size
+	b.buffer.Reset()
+	b.buffer.Write(header)
+	b.buffer.Write(body)
+	return nil
 }
 
 func (b *PlainTextPacket) AddValues(m *Metric, t time.Time, values ...float64) error {
@@ -87,6 +131,7 @@ func (b *PlainTextPacket) AddValueList(v ValueList) error {
 		b.buffer.Truncate(l)
 		return err
 	}
+	b.count++
 	return nil
 }
 
@@ -106,6 +151,37 @@ func (b *PlainTextPacket) addValueList(v ValueList) error {
 	return nil
 }
 
+// AddNotification adds a notification, which the server passes
+// to its notification plugins.
+func (b *PlainTextPacket) AddNotification(n *Notification) error {
+	l := b.buffer.Len()
+	if err := b.addNotification(n); err != nil {
+		b.buffer.Truncate(l)
+		return err
+	}
+	return nil
+}
+
+func (b *PlainTextPacket) addNotification(n *Notification) error {
+	m := Metric{
+		Host:           n.Host,
+		Plugin:         n.Plugin,
+		PluginInstance: n.PluginInstance,
+		Type:           n.Type,
+		TypeInstance:   n.TypeInstance,
+	}
+	if err := b.writeIdentifier(&m); err != nil {
+		return err
+	}
+	if err := b.writeTime(n.Time); err != nil {
+		return err
+	}
+	if err := b.writeInt(typeSeverity, uint64(n.Severity)); err != nil {
+		return err
+	}
+	return b.writeString(typeMessage, n.Message)
+}
+
 func (b *PlainTextPacket) writeIdentifier(m *Metric) error {
 	if m.Host != b.stateHost {
 		if err := b.writeString(typeHost, m.Host); err != nil {
@@ -180,28 +256,32 @@ func (b *PlainTextPacket) writeValues(v ValueList) error {
 		b.buffer.WriteByte(uint8(t))
 	}
 	for i, v := range values {
-		t := m.DSTypes[i]
-		switch t {
-		case GAUGE:
-			if math.IsNaN(float64(v)) {
-				tmp = [8]byte{0, 0, 0, 0, 0, 0, 0xf8, 0x7f}
-			} else {
-				binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(v))
-			}
-		case DERIVE:
-			binary.BigEndian.PutUint64(tmp[:], uint64(int64(v)))
-		case ABSOLUTE:
-			fallthrough
-		case COUNTER:
-			binary.BigEndian.PutUint64(tmp[:], uint64(v))
-		default:
-			panic("unknown type")
-		}
+		putValue(tmp[:], m.DSTypes[i], v)
 		b.buffer.Write(tmp[:])
 	}
 	return nil
 }
 
+// putValue encodes v as data source type t into the 8 bytes of dst.
+func putValue(dst []byte, t DSType, v float64) {
+	switch t {
+	case GAUGE:
+		if math.IsNaN(float64(v)) {
+			copy(dst, []byte{0, 0, 0, 0, 0, 0, 0xf8, 0x7f})
+		} else {
+			binary.LittleEndian.PutUint64(dst, math.Float64bits(v))
+		}
+	case DERIVE:
+		binary.BigEndian.PutUint64(dst, uint64(int64(v)))
+	case ABSOLUTE:
+		fallthrough
+	case COUNTER:
+		binary.BigEndian.PutUint64(dst, uint64(v))
+	default:
+		panic("unknown type")
+	}
+}
+
 func (b *PlainTextPacket) writeString(typ uint16, s string) error {
 	size := len(s) + 4 + 1
 	if size > b.available() {
@@ -230,11 +310,34 @@ func (b *PlainTextPacket) writeInt(typ uint16, n uint64) error {
 }
 
 func (b *PlainTextPacket) Finalize() []byte {
-	return b.buffer.Bytes()
+	return b.body()
+}
+
+func (b *PlainTextPacket) AppendTo(dst []byte) []byte {
+	return append(dst, b.Finalize()...)
+}
+
+// Len is the size of the finalized packet, including any header.
+func (b *PlainTextPacket) Len() int {
+	if b.buffer.Len() == len(b.header) {
+		return 0
+	}
+	return b.buffer.Len()
+}
+
+func (b *PlainTextPacket) Available() int {
+	return b.available()
+}
+
+func (b *PlainTextPacket) ValueListCount() int {
+	return b.count
 }
 
 func (b *PlainTextPacket) Reset() {
 	b.buffer.Reset()
+	b.buffer.Write(b.header)
+	b.count = 0
+	b.sealed = false
 	b.stateHost = ""
 	b.statePlugin = ""
 	b.statePluginInstance = ""
@@ -169,3 +169,87 @@ func TestWriteInt(t *testing.T) {
 		t.Errorf("got %v, want %v", got, want)
 	}
 }
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestPacketSizeTooLarge(t *testing.T) {
+	if _, err := NewSignedPacketSize("u", "p", MaxBufferSize+1); err == nil {
+		t.Error("expected an error for a signed packet")
+	}
+	if _, err := NewEncryptedPacketSize("u", "p", 70000); err == nil {
+		t.Error("expected an error for an encrypted packet")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a plain text packet")
+		}
+	}()
+	NewPlainTextPacketSize(MaxBufferSize + 1)
+}
+
+func TestPacketIntrospection(t *testing.T) {
+	signed, _ := NewSignedPacket("alice", "password")
+	encrypted, _ := NewEncryptedPacket("alice", "password")
+	encrypted.SetRandom(zeroReader{})
+	for _, p := range []SizedPacket{
+		NewPlainTextPacket(),
+		signed,
+		encrypted,
+		&ExecFormatter{},
+	} {
+		if p.Len() != 0 || p.ValueListCount() != 0 {
+			t.Fatalf("%T: new packet is not empty", p)
+		}
+		available := p.Available()
+		_ = p.AddValues(testMetric(), time.Unix(1426076671, 0), 1)
+		_ = p.AddValues(testMetric(), time.Unix(1426076672, 0), 2)
+		if n := p.ValueListCount(); n != 2 {
+			t.Errorf("%T: got %d value lists, want 2", p, n)
+		}
+		if _, unlimited := p.(*ExecFormatter); !unlimited && p.Available() >= available {
+			t.Errorf("%T: available space did not shrink", p)
+		}
+		want := append([]byte("prefix"), p.Finalize()...)
+		if got := p.AppendTo([]byte("prefix")); !reflect.DeepEqual(got, want) {
+			t.Errorf("%T: got %v, want %v", p, got, want)
+		}
+		if p.Len() != len(want)-len("prefix") {
+			t.Errorf("%T: got len %d, want %d", p, p.Len(), len(want)-len("prefix"))
+		}
+		p.Reset()
+		if p.Len() != 0 || p.ValueListCount() != 0 {
+			t.Errorf("%T: reset packet is not empty", p)
+		}
+	}
+}
+
+func TestWriteNotification(t *testing.T) {
+	b := NewPlainTextPacket()
+	n := &Notification{
+		Severity: Warning,
+		Time:     time.Unix(1426076671, 123000000),
+		Host:     "example.com",
+		Plugin:   "golang",
+		Message:  "hi",
+	}
+	if err := b.AddNotification(n); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0, 0, 0, 16, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm', 0,
+		0, 2, 0, 11, 'g', 'o', 'l', 'a', 'n', 'g', 0,
+		0, 8, 0, 12, 0x15, 0x40, 0x0c, 0xff, 0xc7, 0xdf, 0x3b, 0x64,
+		1, 1, 0, 12, 0, 0, 0, 0, 0, 0, 0, 2,
+		1, 0, 0, 7, 'h', 'i', 0,
+	}
+	if got := b.Finalize(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+package cdclient
+
+import (
+	"time"
+)
+
+// RateLimitPolicy decides what happens to packets sent faster than
+// the configured rate limit.
+type RateLimitPolicy byte
+
+const (
+	// RateLimitDrop discards packets over the limit.
+	RateLimitDrop RateLimitPolicy = iota
+	// RateLimitDelay waits until the packet is within the limit.
+	RateLimitDelay
+)
+
+// tokenBucket allows bursts of up to one second worth of tokens.
+// A zero rate means unlimited.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) burst() float64 {
+	if b.rate < 1 {
+		return 1
+	}
+	return b.rate
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = b.burst()
+	} else if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst() {
+			b.tokens = b.burst()
+		}
+	}
+	b.last = now
+}
+
+// wait returns how long until n tokens can be taken. Requests larger
+// than the burst size only need a full bucket, and leave it in debt.
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b.rate == 0 {
+		return 0
+	}
+	if n > b.burst() {
+		n = b.burst()
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n float64) {
+	b.tokens -= n
+}
+
+type rateLimiter struct {
+	packets tokenBucket
+	bytes   tokenBucket
+	policy  RateLimitPolicy
+}
+
+func newRateLimiter(opts *UDPClientOptions) *rateLimiter {
+	if opts.MaxPacketsPerSecond <= 0 && opts.MaxBytesPerSecond <= 0 {
+		return nil
+	}
+	l := &rateLimiter{policy: opts.RateLimitPolicy}
+	if opts.MaxPacketsPerSecond > 0 {
+		l.packets.rate = opts.MaxPacketsPerSecond
+	}
+	if opts.MaxBytesPerSecond > 0 {
+		l.bytes.rate = opts.MaxBytesPerSecond
+	}
+	return l
+}
+
+// reserve takes the tokens for a packet of n bytes if they are
+// available, otherwise it returns how long until they will be.
+func (l *rateLimiter) reserve(now time.Time, n int) time.Duration {
+	l.packets.refill(now)
+	l.bytes.refill(now)
+	d := l.packets.wait(1)
+	if bd := l.bytes.wait(float64(n)); bd > d {
+		d = bd
+	}
+	if d == 0 {
+		l.packets.take(1)
+		l.bytes.take(float64(n))
+	}
+	return d
+}
+
+// allow reports whether a packet of n bytes may be sent, delaying
+// it first if that is the policy.
+func (l *rateLimiter) allow(n int, stats *ClientStats) bool {
+	d := l.reserve(time.Now(), n)
+	if d == 0 {
+		return true
+	}
+	stats.PacketsLimited++
+	if l.policy != RateLimitDelay {
+		return false
+	}
+	for d > 0 {
+		time.Sleep(d)
+		d = l.reserve(time.Now(), n)
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package cdclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	l := newRateLimiter(&UDPClientOptions{
+		MaxPacketsPerSecond: 2,
+		MaxBytesPerSecond:   1000,
+	})
+	now := time.Unix(1426076671, 0)
+
+	if d := l.reserve(now, 100); d != 0 {
+		t.Fatalf("first packet delayed %v", d)
+	}
+	if d := l.reserve(now, 100); d != 0 {
+		t.Fatalf("second packet delayed %v", d)
+	}
+	if d := l.reserve(now, 100); d != 500*time.Millisecond {
+		t.Fatalf("third packet got %v, want 500ms", d)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if d := l.reserve(now, 100); d != 0 {
+		t.Fatalf("packet after refill delayed %v", d)
+	}
+
+	// After a second the byte bucket is full again.
+	now = now.Add(time.Second)
+	if d := l.reserve(now, 900); d != 0 {
+		t.Fatalf("large packet delayed %v", d)
+	}
+	if d := l.reserve(now, 200); d != 100*time.Millisecond {
+		t.Fatalf("got %v, want 100ms", d)
+	}
+}
+
+func TestUDPClientRateLimitDrop(t *testing.T) {
+	tr := &MemoryTransport{}
+	c, err := NewUDPClient(tr, UDPClientOptions{MaxPacketsPerSecond: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := testMetric()
+	for i := 0; i < 3; i++ {
+		_ = c.AddValues(m, time.Now(), 1)
+		if err := c.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(tr.Packets()); n != 1 {
+		t.Fatalf("got %d packets, want 1", n)
+	}
+	if n := c.Stats().PacketsLimited; n != 2 {
+		t.Fatalf("got %d limited packets, want 2", n)
+	}
+}
@@ -0,0 +1,161 @@
+package cdclient
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ShardMode byte
+
+const (
+	// ShardPerCPU lets goroutines running on the same CPU share a shard,
+	// so concurrent callers rarely contend on one.
+	ShardPerCPU ShardMode = iota
+	// ShardByMetric always sends a metric to the same shard,
+	// chosen by a hash of its identifier.
+	ShardByMetric
+)
+
+// ShardedUDPClient spreads metrics over several UDPClients, each with its
+// own lock and packet, so goroutines adding values concurrently do not
+// contend on a single lock. Each shard flushes independently.
+// The client is safe to use from multiple goroutines concurrently.
+type ShardedUDPClient struct {
+	mode   ShardMode
+	shards []*UDPClient
+	next   uint32
+	// hints holds shard indexes, sync.Pool caches them per P
+	// so each CPU keeps using the same shard.
+	hints     sync.Pool
+	statsStop chan struct{}
+	statsOnce sync.Once
+}
+
+// DialShardedUDP connects n shards to the collectd server at address,
+// each shard has its own socket. Stats are reported for all shards
+// together, see UDPClientOptions.StatsInterval.
+func DialShardedUDP(address string, n int, mode ShardMode, opts UDPClientOptions) (*ShardedUDPClient, error) {
+	if n < 1 {
+		return nil, errors.New("at least one shard is required")
+	}
+	shardOpts := opts
+	shardOpts.StatsInterval = 0
+	shards := make([]*UDPClient, 0, n)
+	for i := 0; i < n; i++ {
+		c, err := DialUDP(address, shardOpts)
+		if err != nil {
+			for _, c := range shards {
+				_ = c.Close()
+			}
+			return nil, err
+		}
+		shards = append(shards, c)
+	}
+	c := NewShardedUDPClient(mode, shards...)
+	c.statsStop = startStats(c, &opts)
+	return c, nil
+}
+
+// NewShardedUDPClient creates a sharded client from existing clients,
+// it panics if no clients are given.
+func NewShardedUDPClient(mode ShardMode, shards ...*UDPClient) *ShardedUDPClient {
+	if len(shards) == 0 {
+		panic("no shards")
+	}
+	c := &ShardedUDPClient{
+		mode:   mode,
+		shards: shards,
+	}
+	c.hints.New = func() interface{} {
+		i := atomic.AddUint32(&c.next, 1)
+		return &i
+	}
+	return c
+}
+
+func (c *ShardedUDPClient) shard(m *Metric) *UDPClient {
+	var i uint32
+	switch c.mode {
+	case ShardByMetric:
+		i = metricHash(m)
+	default:
+		hint := c.hints.Get().(*uint32)
+		i = *hint
+		c.hints.Put(hint)
+	}
+	return c.shards[i%uint32(len(c.shards))]
+}
+
+func (c *ShardedUDPClient) AddValues(m *Metric, t time.Time, values ...float64) error {
+	return c.shard(m).AddValues(m, t, values...)
+}
+
+func (c *ShardedUDPClient) AddValueList(v ValueList) error {
+	return c.shard(v.Metric).AddValueList(v)
+}
+
+// Flush flushes every shard, returning the first error.
+func (c *ShardedUDPClient) Flush() error {
+	var err error
+	for _, s := range c.shards {
+		if serr := s.Flush(); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// Stats returns the sum of the statistics of all shards.
+func (c *ShardedUDPClient) Stats() ClientStats {
+	var stats ClientStats
+	for _, s := range c.shards {
+		stats.add(s.Stats())
+	}
+	return stats
+}
+
+// Close closes every shard, returning the first error.
+func (c *ShardedUDPClient) Close() error {
+	c.statsOnce.Do(func() {
+		if c.statsStop != nil {
+			close(c.statsStop)
+			c.statsStop = nil
+		}
+	})
+	var err error
+	for _, s := range c.shards {
+		if serr := s.Close(); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// metricHash is an allocation free FNV-1a hash of a metric identifier.
+func metricHash(m *Metric) uint32 {
+	h := uint32(fnvOffset)
+	h = fnvString(h, m.Host)
+	h = fnvString(h, m.Plugin)
+	h = fnvString(h, m.PluginInstance)
+	h = fnvString(h, m.Type)
+	h = fnvString(h, m.TypeInstance)
+	return h
+}
+
+const (
+	fnvOffset = 2166136261
+	fnvPrime  = 16777619
+)
+
+func fnvString(h uint32, s string) uint32 {
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= fnvPrime
+	}
+	// Separate fields so "ab","c" and "a","bc" differ.
+	h ^= '/'
+	h *= fnvPrime
+	return h
+}
@@ -0,0 +1,101 @@
+package cdclient
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+type discardTransport struct{}
+
+func (discardTransport) Write(b []byte) (int, error) { return len(b), nil }
+func (discardTransport) Close() error                { return nil }
+
+func TestShardedUDPClientByMetric(t *testing.T) {
+	trs := []*MemoryTransport{{}, {}, {}, {}}
+	var shards []*UDPClient
+	for _, tr := range trs {
+		c, err := NewUDPClient(tr, UDPClientOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards = append(shards, c)
+	}
+	c := NewShardedUDPClient(ShardByMetric, shards...)
+
+	m := testMetric()
+	for i := 0; i < 10; i++ {
+		if err := c.AddValues(m, time.Now(), float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	used := 0
+	for _, tr := range trs {
+		if len(tr.Packets()) != 0 {
+			used++
+		}
+	}
+	if used != 1 {
+		t.Fatalf("metric was sent by %d shards, want 1", used)
+	}
+}
+
+func TestDialShardedUDPNoShards(t *testing.T) {
+	if _, err := DialShardedUDP("127.0.0.1:25826", 0, ShardPerCPU, UDPClientOptions{}); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func benchmarkParallel(bench *testing.B, sink MetricSink) {
+	m := &Metric{
+		Host:     "example.com",
+		Plugin:   "golang",
+		Type:     "foobar",
+		DSTypes:  []DSType{DERIVE, GAUGE},
+		Interval: 10 * time.Second,
+	}
+	t := time.Unix(1426076671, 123000000)
+	bench.ReportAllocs()
+	bench.ResetTimer()
+	bench.RunParallel(func(pb *testing.PB) {
+		v := ValueList{Metric: m, Time: t, Values: []float64{1, 2}}
+		for pb.Next() {
+			if err := sink.AddValueList(v); err != nil {
+				bench.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkUDPClientParallel(bench *testing.B) {
+	c, err := NewUDPClient(discardTransport{}, UDPClientOptions{})
+	if err != nil {
+		bench.Fatal(err)
+	}
+	benchmarkParallel(bench, c)
+}
+
+func BenchmarkShardedUDPClientParallel(bench *testing.B) {
+	var shards []*UDPClient
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		c, err := NewUDPClient(discardTransport{}, UDPClientOptions{})
+		if err != nil {
+			bench.Fatal(err)
+		}
+		shards = append(shards, c)
+	}
+	benchmarkParallel(bench, NewShardedUDPClient(ShardPerCPU, shards...))
+}
+
+func TestShardedUDPClientCloseTwice(t *testing.T) {
+	c, err := DialShardedUDP("127.0.0.1:25826", 2, ShardPerCPU, UDPClientOptions{StatsInterval: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+	_ = c.Close()
+}
@@ -1,10 +1,9 @@
 package cdclient
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
-	"errors"
+	"fmt"
 	"hash"
 )
 
@@ -12,7 +11,6 @@ type SignedPacket struct {
 	PlainTextPacket
 	username, password []byte
 	hmac               *hmacSha256
-	signed             bytes.Buffer
 }
 
 func NewSignedPacket(username, password string) (*SignedPacket, error) {
@@ -20,37 +18,79 @@ func NewSignedPacket(username, password string) (*SignedPacket, error) {
 }
 
 func NewSignedPacketSize(username, password string, size int) (*SignedPacket, error) {
-	if len(username) > 64 {
-		return nil, errors.New("username must be 0-64 characters")
+	if size > MaxBufferSize {
+		return nil, fmt.Errorf("buffer size must be at most %d bytes", MaxBufferSize)
 	}
-	if len(password) > 64 {
-		return nil, errors.New("password must be 0-64 characters")
+	if err := checkUsername(username, size, 36); err != nil {
+		return nil, err
 	}
 	b := &SignedPacket{}
 	b.hmac = newHmacSha256([]byte(password))
 	b.username = []byte(username)
-	b.PlainTextPacket.init(size - 36 - len(username))
+	b.PlainTextPacket.init(size-36-len(username), signedHeader(username))
 	return b, nil
 }
 
+// signedHeader returns the signature part with an empty signature.
+func signedHeader(username string) []byte {
+	header := make([]byte, 36+len(username))
+	binary.BigEndian.PutUint16(header[0:2], uint16(typeSignSHA256))
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(header)))
+	copy(header[36:], username)
+	return header
+}
+
+// MaxUsernameLength is the longest username that leaves MinimumBufferSize
+// bytes for metrics in a signed or encrypted packet of MaxBufferSize bytes,
+// smaller packets allow shorter usernames. Passwords are never sent, so
+// they may be of any length.
+const MaxUsernameLength = MaxBufferSize - 42 - MinimumBufferSize
+
+// checkUsername checks that username leaves MinimumBufferSize bytes for
+// metrics in a packet of size bytes with a header of overhead bytes.
+func checkUsername(username string, size, overhead int) error {
+	max := size - overhead - MinimumBufferSize
+	if max > MaxUsernameLength {
+		max = MaxUsernameLength
+	}
+	if len(username) > max {
+		return fmt.Errorf("username must be 0-%d characters", max)
+	}
+	return nil
+}
+
+// SetCredentials changes the username and password used to sign the
+// packet, any buffered metrics are signed with the new credentials.
+func (b *SignedPacket) SetCredentials(username, password string) error {
+	size := b.size + 36 + len(b.username)
+	if err := checkUsername(username, size, 36); err != nil {
+		return err
+	}
+	err := b.setHeader(signedHeader(username), size-36-len(username))
+	if err != nil {
+		return err
+	}
+	b.hmac = newHmacSha256([]byte(password))
+	b.username = []byte(username)
+	return nil
+}
+
+// Finalize signs the packet in place, more metrics may still be
+// added to the packet after it is finalized.
 func (b *SignedPacket) Finalize() []byte {
-	if b.buffer.Len() == 0 {
+	if b.Len() == 0 {
 		return nil
 	}
-	b.signed.Reset()
+	out := b.buffer.Bytes()
+	// The signature covers the username and the metrics.
 	b.hmac.reset()
-	size := uint16(36 + len(b.username))
-	b.hmac.write(b.username)
-	b.hmac.write(b.buffer.Bytes())
-	tmp := [4]byte{}
-	binary.BigEndian.PutUint16(tmp[0:2], uint16(typeSignSHA256))
-	binary.BigEndian.PutUint16(tmp[2:4], size)
-	b.signed.Write(tmp[:])
-	mac := b.hmac.sum()
-	b.signed.Write(mac[:])
-	b.signed.Write(b.username)
-	b.signed.Write(b.buffer.Bytes())
-	return b.signed.Bytes()
+	b.hmac.write(out[36:])
+	copy(out[4:36], b.hmac.sum())
+	return out
+}
+
+func (b *SignedPacket) AppendTo(dst []byte) []byte {
+	return append(dst, b.Finalize()...)
 }
 
 type hmacSha256 struct {
@@ -69,7 +109,9 @@ func newHmacSha256(key []byte) *hmacSha256 {
 	}
 	blocksize := 64
 	if len(key) > blocksize {
-		panic("unreachable")
+		// As in RFC 2104, long keys are hashed first.
+		sum := sha256.Sum256(key)
+		key = sum[:]
 	}
 	copy(hm.ipad[:], key)
 	copy(hm.opad[:], key)
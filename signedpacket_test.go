@@ -5,6 +5,10 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -38,6 +42,55 @@ func BenchmarkFormatSignedPacket(bench *testing.B) {
 	}
 }
 
+func TestHmacSha256LongKey(t *testing.T) {
+	for _, n := range []int{0, 63, 64, 65, 128, 1000} {
+		key := make([]byte, n)
+		for i := range key {
+			key[i] = byte(i)
+		}
+		hm1 := hmac.New(sha256.New, key)
+		hm1.Write([]byte("foobar"))
+		hm2 := newHmacSha256(key)
+		hm2.write([]byte("foobar"))
+		if !hmac.Equal(hm1.Sum(nil), hm2.sum()) {
+			t.Fatalf("key length %d gave an unexpected result", n)
+		}
+	}
+}
+
+func TestSignedPacketLongCredentials(t *testing.T) {
+	username := strings.Repeat("u", 100)
+	password := strings.Repeat("p", 128)
+	b, err := NewSignedPacket(username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = b.AddValues(testMetric(), time.Unix(1426076671, 0), 1)
+	if !checkSignature(b.Finalize(), username, password) {
+		t.Fatal("invalid signature")
+	}
+	if _, err := NewSignedPacket(strings.Repeat("u", DefaultBufferSize), password); err == nil {
+		t.Fatal("expected error for a username larger than the packet")
+	}
+}
+
+func TestMaxUsernameLength(t *testing.T) {
+	username := strings.Repeat("u", MaxUsernameLength)
+	if _, err := NewSignedPacketSize(username, "password", MaxBufferSize); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEncryptedPacketSize(username, "password", MaxBufferSize); err != nil {
+		t.Fatal(err)
+	}
+	username += "u"
+	if _, err := NewSignedPacketSize(username, "password", MaxBufferSize); err == nil {
+		t.Fatal("expected error for a username longer than MaxUsernameLength")
+	}
+	if _, err := NewEncryptedPacketSize(username, "password", MaxBufferSize); err == nil {
+		t.Fatal("expected error for a username longer than MaxUsernameLength")
+	}
+}
+
 func TestHmacSha256(t *testing.T) {
 	hm1 := hmac.New(sha256.New, []byte("password"))
 	hm1.Write([]byte("foobar"))
@@ -62,3 +115,155 @@ func TestHmacSha256(t *testing.T) {
 		t.Fatalf("%s != %s", h1s, h2s)
 	}
 }
+
+func TestSignedPacketSetCredentials(t *testing.T) {
+	b, _ := NewSignedPacket("alice", "password")
+	_ = b.AddValues(testMetric(), time.Unix(1426076671, 0), 1)
+	if err := b.SetCredentials("bob", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := NewSignedPacket("bob", "hunter2")
+	_ = want.AddValues(testMetric(), time.Unix(1426076671, 0), 1)
+	if !reflect.DeepEqual(b.Finalize(), want.Finalize()) {
+		t.Fatalf("got %v, want %v", b.Finalize(), want.Finalize())
+	}
+}
+
+// checkSignature reports whether a signed packet has a valid signature
+// for the given username and password.
+func checkSignature(p []byte, username, password string) bool {
+	if len(p) < 36+len(username) || string(p[36:36+len(username)]) != username {
+		return false
+	}
+	hm := hmac.New(sha256.New, []byte(password))
+	hm.Write(p[36:])
+	return hmac.Equal(hm.Sum(nil), p[4:36])
+}
+
+func TestUDPClientSetCredentials(t *testing.T) {
+	tr := &MemoryTransport{}
+	c, err := NewUDPClient(tr, UDPClientOptions{
+		Mode:     UDPSign,
+		Username: "alice",
+		Password: "password",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.AddValues(testMetric(), time.Now(), 1)
+	if err := c.SetCredentials("bob", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.AddValues(testMetric(), time.Now(), 2)
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	packets := tr.Packets()
+	if len(packets) != 2 {
+		t.Fatalf("got %d packets, want 2", len(packets))
+	}
+	if !checkSignature(packets[0], "alice", "password") {
+		t.Error("first packet not signed with the old credentials")
+	}
+	if !checkSignature(packets[1], "bob", "hunter2") {
+		t.Error("second packet not signed with the new credentials")
+	}
+}
+
+func TestUDPClientWatchAuthFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collectd.auth")
+	if err := os.WriteFile(path, []byte("alice: password\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAuthFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &MemoryTransport{}
+	c, err := NewUDPClient(tr, UDPClientOptions{
+		Mode:     UDPSign,
+		Username: "alice",
+		Password: "password",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.WatchAuthFile(a, "alice", time.Millisecond)
+
+	if err := os.WriteFile(path, []byte("alice: hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		_ = c.AddValues(testMetric(), time.Now(), 1)
+		_ = c.Flush()
+		packets := tr.Packets()
+		if checkSignature(packets[len(packets)-1], "alice", "hunter2") {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("credentials were not rotated")
+}
+
+func TestUDPClientWatchRefreshedAuthFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collectd.auth")
+	if err := os.WriteFile(path, []byte("alice: hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// The file was already loaded, so Refresh never reports a change.
+	a, err := NewAuthFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &MemoryTransport{}
+	c, err := NewUDPClient(tr, UDPClientOptions{
+		Mode:     UDPSign,
+		Username: "alice",
+		Password: "password",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.WatchAuthFile(a, "alice", time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		_ = c.AddValues(testMetric(), time.Now(), 1)
+		_ = c.Flush()
+		packets := tr.Packets()
+		if checkSignature(packets[len(packets)-1], "alice", "hunter2") {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("credentials were not switched")
+}
+
+// fillPacket adds value lists until p is full.
+func fillPacket(p Packet, v ValueList) {
+	for i := 0; ; i++ {
+		v.Time = time.Unix(1426076671, int64(i))
+		if p.AddValueList(v) != nil {
+			return
+		}
+	}
+}
+
+func BenchmarkFinalizeFullSignedPacket(bench *testing.B) {
+	b, _ := NewSignedPacket("username", "password")
+	fillPacket(b, ValueList{Metric: testMetric(), Values: []float64{1}})
+	bench.ReportAllocs()
+	bench.SetBytes(int64(len(b.Finalize())))
+	bench.ResetTimer()
+	for n := 0; n < bench.N; n++ {
+		b.Finalize()
+	}
+}
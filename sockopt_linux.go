@@ -0,0 +1,103 @@
+package cdclient
+
+import (
+	"net"
+	"strings"
+	"syscall"
+)
+
+func setSockopts(network, address string, c syscall.RawConn, opts *UDPClientOptions) error {
+	if !opts.hasSockopts() {
+		return nil
+	}
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = setSockoptsFd(int(fd), network, address, opts)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+func setSockoptsFd(fd int, network, address string, opts *UDPClientOptions) error {
+	if opts.SendBufferSize != 0 {
+		err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, opts.SendBufferSize)
+		if err != nil {
+			return err
+		}
+	}
+	if !strings.HasPrefix(network, "udp") {
+		if opts.Interface != "" {
+			return syscall.SetsockoptString(fd, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, opts.Interface)
+		}
+		return nil
+	}
+
+	ipv6 := strings.HasSuffix(network, "6")
+	multicast := false
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			multicast = ip.IsMulticast()
+		}
+	}
+
+	if opts.TTL != 0 {
+		var level, opt int
+		switch {
+		case ipv6 && multicast:
+			level, opt = syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS
+		case ipv6:
+			level, opt = syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS
+		case multicast:
+			level, opt = syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL
+		default:
+			level, opt = syscall.IPPROTO_IP, syscall.IP_TTL
+		}
+		if err := syscall.SetsockoptInt(fd, level, opt, opts.TTL); err != nil {
+			return err
+		}
+	}
+
+	if opts.TOS != 0 {
+		var err error
+		if ipv6 {
+			err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, opts.TOS)
+		} else {
+			err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TOS, opts.TOS)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.DisableMulticastLoopback && multicast {
+		var err error
+		if ipv6 {
+			err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, 0)
+		} else {
+			err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 0)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Interface != "" {
+		if !multicast {
+			return syscall.SetsockoptString(fd, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, opts.Interface)
+		}
+		ifi, err := net.InterfaceByName(opts.Interface)
+		if err != nil {
+			return err
+		}
+		if ipv6 {
+			return syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_IF, ifi.Index)
+		}
+		return syscall.SetsockoptIPMreqn(fd, syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, &syscall.IPMreqn{
+			Ifindex: int32(ifi.Index),
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package cdclient
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func getsockoptInt(t *testing.T, c *UDPClient, level, opt int) int {
+	rc, err := c.conn.(syscall.Conn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	cerr := rc.Control(func(fd uintptr) {
+		v, err = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	if cerr != nil {
+		t.Fatal(cerr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestUDPClientSockopts(t *testing.T) {
+	c, err := DialUDP("127.0.0.1:25826", UDPClientOptions{
+		TTL:            7,
+		TOS:            34 << 2,
+		SendBufferSize: 32768,
+		LocalAddress:   "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if v := getsockoptInt(t, c, syscall.IPPROTO_IP, syscall.IP_TTL); v != 7 {
+		t.Errorf("ttl got %d, want 7", v)
+	}
+	if v := getsockoptInt(t, c, syscall.IPPROTO_IP, syscall.IP_TOS); v != 34<<2 {
+		t.Errorf("tos got %d, want %d", v, 34<<2)
+	}
+	// The kernel doubles the requested size for bookkeeping overhead.
+	if v := getsockoptInt(t, c, syscall.SOL_SOCKET, syscall.SO_SNDBUF); v < 32768 {
+		t.Errorf("sndbuf got %d, want >= 32768", v)
+	}
+}
+
+func TestUDPClientMulticastSockopts(t *testing.T) {
+	c, err := DialUDP(net.JoinHostPort(DefaultIPv4Address, DefaultPort), UDPClientOptions{
+		TTL:                      3,
+		Interface:                "lo",
+		DisableMulticastLoopback: true,
+	})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer c.Close()
+
+	if v := getsockoptInt(t, c, syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL); v != 3 {
+		t.Errorf("multicast ttl got %d, want 3", v)
+	}
+	if v := getsockoptInt(t, c, syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP); v != 0 {
+		t.Errorf("multicast loop got %d, want 0", v)
+	}
+}
+
+func TestUDPClientMulticastLoopback(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil || lo.Flags&net.FlagMulticast == 0 {
+		t.Skip("loopback interface does not support multicast")
+	}
+	group := &net.UDPAddr{IP: net.ParseIP(DefaultIPv4Address), Port: 25826}
+	l, err := net.ListenMulticastUDP("udp4", lo, group)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+
+	c, err := DialUDP(group.String(), UDPClientOptions{TTL: 1, Interface: "lo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.AddValues(testMetric(), time.Now(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	_ = l.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, DefaultBufferSize)
+	if _, err := l.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package cdclient
+
+import (
+	"errors"
+	"syscall"
+)
+
+func setSockopts(network, address string, c syscall.RawConn, opts *UDPClientOptions) error {
+	if opts.hasSockopts() {
+		return errors.New("socket options are only supported on linux")
+	}
+	return nil
+}
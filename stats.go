@@ -0,0 +1,147 @@
+package cdclient
+
+import (
+	"os"
+	"time"
+)
+
+// ClientStats counts the work done by a client since it was created.
+type ClientStats struct {
+	// PacketsSent and BytesSent count successful writes.
+	PacketsSent uint64
+	BytesSent   uint64
+	// FullFlushes counts packets sent because they ran out of space.
+	FullFlushes uint64
+	// WriteErrors counts failed writes.
+	WriteErrors uint64
+	// PacketsDropped counts packets discarded because their write failed.
+	PacketsDropped uint64
+	// ValueListsDropped counts value lists discarded before being
+	// encoded, e.g. by an AsyncClient with a full queue.
+	ValueListsDropped uint64
+	// PacketsLimited counts packets dropped or delayed by rate limiting.
+	PacketsLimited uint64
+}
+
+func (s *ClientStats) add(o ClientStats) {
+	s.PacketsSent += o.PacketsSent
+	s.BytesSent += o.BytesSent
+	s.FullFlushes += o.FullFlushes
+	s.WriteErrors += o.WriteErrors
+	s.PacketsDropped += o.PacketsDropped
+	s.ValueListsDropped += o.ValueListsDropped
+	s.PacketsLimited += o.PacketsLimited
+}
+
+// countWrite records the outcome of writing a single packet.
+func (s *ClientStats) countWrite(n int, err error) {
+	if err != nil {
+		s.WriteErrors++
+		s.PacketsDropped++
+		return
+	}
+	s.PacketsSent++
+	s.BytesSent += uint64(n)
+}
+
+// StatsReporter adds ClientStats to a MetricSink as collectd metrics with
+// the plugin "cdclient", in the same spirit as the statistics collectd's
+// network plugin reports about itself. Each counter is a "derive" metric
+// named by its type instance.
+type StatsReporter struct {
+	metrics [7]Metric
+	values  [7]float64
+}
+
+// NewStatsReporter creates a reporter for metrics of the given host,
+// plugin instance and interval.
+func NewStatsReporter(host, pluginInstance string, interval time.Duration) *StatsReporter {
+	r := &StatsReporter{}
+	for i, typeInstance := range [...]string{
+		"packets_sent",
+		"bytes_sent",
+		"full_flushes",
+		"write_errors",
+		"packets_dropped",
+		"value_lists_dropped",
+		"packets_limited",
+	} {
+		r.metrics[i] = Metric{
+			Host:           host,
+			Plugin:         "cdclient",
+			PluginInstance: pluginInstance,
+			Type:           "derive",
+			TypeInstance:   typeInstance,
+			DSTypes:        []DSType{DERIVE},
+			Interval:       interval,
+		}
+	}
+	return r
+}
+
+// Report adds the stats to sink with the time t.
+func (r *StatsReporter) Report(sink MetricSink, t time.Time, s ClientStats) error {
+	r.values = [...]float64{
+		float64(s.PacketsSent),
+		float64(s.BytesSent),
+		float64(s.FullFlushes),
+		float64(s.WriteErrors),
+		float64(s.PacketsDropped),
+		float64(s.ValueListsDropped),
+		float64(s.PacketsLimited),
+	}
+	for i := range r.metrics {
+		err := sink.AddValueList(ValueList{
+			Metric: &r.metrics[i],
+			Time:   t,
+			Values: r.values[i : i+1],
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statsClient is a client that can report its own stats.
+type statsClient interface {
+	MetricSink
+	Flush() error
+	Stats() ClientStats
+}
+
+// startStats reports the stats of c through itself every
+// opts.StatsInterval, until the returned channel is closed.
+// It returns nil if stats are not reported.
+func startStats(c statsClient, opts *UDPClientOptions) chan struct{} {
+	if opts.StatsInterval <= 0 {
+		return nil
+	}
+	host := opts.StatsHost
+	if host == "" {
+		host = opts.Defaults.Host
+	}
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	r := NewStatsReporter(host, "", opts.StatsInterval)
+	stop := make(chan struct{})
+	go reportStats(c, r, opts.StatsInterval, stop)
+	return stop
+}
+
+func reportStats(c statsClient, r *StatsReporter, interval time.Duration, stop chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			// Reporting errors have nowhere to go, they
+			// are already counted in the stats.
+			_ = r.Report(c, now, c.Stats())
+			_ = c.Flush()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package cdclient
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type failingTransport struct{}
+
+func (failingTransport) Write(b []byte) (int, error) { return 0, errors.New("write failed") }
+func (failingTransport) Close() error                { return nil }
+
+func TestUDPClientStats(t *testing.T) {
+	tr := &MemoryTransport{}
+	c, err := NewUDPClient(tr, UDPClientOptions{BufferSize: MinimumBufferSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := testMetric()
+	now := time.Unix(1426076671, 0)
+	for i := 0; i < 100; i++ {
+		if err := c.AddValues(m, now.Add(time.Duration(i)*time.Second), float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	packets := tr.Packets()
+	bytes := 0
+	for _, p := range packets {
+		bytes += len(p)
+	}
+	s := c.Stats()
+	if s.PacketsSent != uint64(len(packets)) || s.BytesSent != uint64(bytes) {
+		t.Fatalf("got %d packets %d bytes, want %d and %d", s.PacketsSent, s.BytesSent, len(packets), bytes)
+	}
+	if s.FullFlushes != uint64(len(packets)-1) {
+		t.Fatalf("got %d full flushes, want %d", s.FullFlushes, len(packets)-1)
+	}
+
+	if err := c.SetTransport(failingTransport{}, UDPClientOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.AddValues(m, now, 1)
+	if err := c.Flush(); err == nil {
+		t.Fatal("expected error")
+	}
+	s = c.Stats()
+	if s.WriteErrors != 1 || s.PacketsDropped != 1 {
+		t.Fatalf("got %d write errors %d dropped, want 1 and 1", s.WriteErrors, s.PacketsDropped)
+	}
+}
+
+func TestStatsReporter(t *testing.T) {
+	p := NewPlainTextPacket()
+	r := NewStatsReporter("example.com", "", 10*time.Second)
+	err := r.Report(p, time.Unix(1426076671, 0), ClientStats{PacketsSent: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countValueLists(p.Finalize()); n != len(r.metrics) {
+		t.Fatalf("got %d value lists, want %d", n, len(r.metrics))
+	}
+	for _, m := range r.metrics {
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build linux && !amd64 && !386
+// +build linux,!amd64,!386
+
+package cdclient
+
+import "syscall"
+
+const sysSendmmsg = syscall.SYS_SENDMMSG
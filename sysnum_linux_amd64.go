@@ -0,0 +1,4 @@
+package cdclient
+
+// The syscall package does not define SYS_SENDMMSG on this architecture.
+const sysSendmmsg = 307
@@ -0,0 +1,51 @@
+package cdclient
+
+import (
+	"errors"
+	"sync"
+)
+
+// A Transport delivers complete packets to a collectd server. Each call to
+// Write must send exactly one datagram. Any net.Conn created with the "udp"
+// or "unixgram" networks is a valid Transport.
+type Transport interface {
+	Write(b []byte) (int, error)
+	Close() error
+}
+
+// MemoryTransport is an in memory Transport that records a copy of every
+// packet written to it, it is mainly useful for testing.
+// It is safe to use from multiple goroutines concurrently.
+type MemoryTransport struct {
+	lock    sync.Mutex
+	packets [][]byte
+	closed  bool
+}
+
+var errTransportClosed = errors.New("transport closed")
+
+func (t *MemoryTransport) Write(b []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		return 0, errTransportClosed
+	}
+	t.packets = append(t.packets, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (t *MemoryTransport) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.closed = true
+	return nil
+}
+
+// Packets returns the packets written so far and clears the record.
+func (t *MemoryTransport) Packets() [][]byte {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	packets := t.packets
+	t.packets = nil
+	return packets
+}
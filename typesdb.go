@@ -0,0 +1,86 @@
+package cdclient
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// TypesDB maps type names to the types of their data sources,
+// as described by collectd's types.db.
+type TypesDB map[string][]DSType
+
+// builtinTypes are common single value types,
+// used when no TypesDB is given.
+var builtinTypes = TypesDB{
+	"absolute": {ABSOLUTE},
+	"bytes":    {GAUGE},
+	"count":    {GAUGE},
+	"counter":  {COUNTER},
+	"derive":   {DERIVE},
+	"gauge":    {GAUGE},
+	"percent":  {GAUGE},
+}
+
+// LoadTypesDB reads a types.db file, see ParseTypesDB.
+func LoadTypesDB(path string) (TypesDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseTypesDB(f)
+}
+
+// ParseTypesDB reads collectd's types.db format, for example:
+//
+//	if_octets  rx:DERIVE:0:U, tx:DERIVE:0:U
+//
+// The minimum and maximum of data sources are ignored.
+func ParseTypesDB(r io.Reader) (TypesDB, error) {
+	db := TypesDB{}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		name := fields[0]
+		sources := strings.Split(strings.Join(fields[1:], " "), ",")
+		dsTypes := make([]DSType, 0, len(sources))
+		for _, ds := range sources {
+			parts := strings.Split(strings.TrimSpace(ds), ":")
+			if len(parts) != 4 {
+				return nil, fmt.Errorf("line %d: %s: invalid data source %q", n, name, ds)
+			}
+			t, err := parseDSType(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %s", n, name, err)
+			}
+			dsTypes = append(dsTypes, t)
+		}
+		db[name] = dsTypes
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func parseDSType(s string) (DSType, error) {
+	switch strings.ToUpper(s) {
+	case "COUNTER":
+		return COUNTER, nil
+	case "GAUGE":
+		return GAUGE, nil
+	case "DERIVE":
+		return DERIVE, nil
+	case "ABSOLUTE":
+		return ABSOLUTE, nil
+	default:
+		return 0, fmt.Errorf("unknown data source type %q", s)
+	}
+}
@@ -0,0 +1,37 @@
+package cdclient
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTypesDB(t *testing.T) {
+	db, err := ParseTypesDB(strings.NewReader(`# comment
+if_octets  rx:DERIVE:0:U, tx:DERIVE:0:U
+load       shortterm:GAUGE:0:5000,	midterm:GAUGE:0:5000, longterm:GAUGE:0:5000
+
+vs_threads value:gauge:0:65535
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := TypesDB{
+		"if_octets":  {DERIVE, DERIVE},
+		"load":       {GAUGE, GAUGE, GAUGE},
+		"vs_threads": {GAUGE},
+	}
+	if !reflect.DeepEqual(db, expected) {
+		t.Fatalf("got %v, expected %v", db, expected)
+	}
+
+	for _, s := range []string{
+		"bytes value:GAUGE:0",
+		"bytes value:STRING:0:U",
+		"bytes",
+	} {
+		if _, err := ParseTypesDB(strings.NewReader(s)); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
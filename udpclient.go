@@ -2,11 +2,20 @@ package cdclient
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"sync"
+	"syscall"
 	"time"
 )
 
+// The multicast groups and port collectd uses by default.
+const (
+	DefaultIPv4Address = "239.192.74.66"
+	DefaultIPv6Address = "ff18::efc0:4a42"
+	DefaultPort        = "25826"
+)
+
 type UDPMode byte
 
 const (
@@ -21,23 +30,118 @@ type UDPClientOptions struct {
 	Mode UDPMode
 	// Username and password for the "Sign" and "Encrypt" modes.
 	Username, Password string
+	// Credentials, when set, supplies the username and password instead.
+	Credentials CredentialProvider
 	// Size of the send buffer. When zero, DefaultBufferSize is used.
 	BufferSize int
+	// PathMTU derives the buffer size from the route MTU of the connected
+	// socket each time the client dials, so packets are as large as
+	// possible without fragmenting. BufferSize is used when the MTU is
+	// unknown, e.g. on platforms other than linux.
+	PathMTU bool
+
+	// The socket options below are applied when the client dials,
+	// when zero the system default is used. They are currently only
+	// supported on linux.
+
+	// TTL sets the time to live (IPv6 hop limit) of sent packets, it
+	// applies to multicast packets when dialing a multicast address.
+	TTL int
+	// Interface is the name of the network interface packets are sent
+	// from (SO_BINDTODEVICE), or the outgoing interface when dialing a
+	// multicast address.
+	Interface string
+	// TOS sets the IPv4 type of service byte or the IPv6 traffic class.
+	// A DSCP value must be shifted left by two, e.g. AF41 is 34 << 2.
+	TOS int
+	// SendBufferSize sets the size of the kernel socket send buffer.
+	SendBufferSize int
+	// LocalAddress is the local address packets are sent from.
+	LocalAddress string
+	// ResolveInterval is how often the client dials the server address
+	// again, so DNS changes are picked up. When zero the address is only
+	// resolved by Dial and Reconnect.
+	ResolveInterval time.Duration
+	// DisableMulticastLoopback stops multicast packets being looped
+	// back to listeners on the sending host.
+	DisableMulticastLoopback bool
+
+	// BatchSize is the number of full packets accumulated before they are
+	// sent together, on linux with a single sendmmsg syscall. When zero
+	// each packet is sent as soon as it is full.
+	BatchSize int
+
+	// MaxPacketsPerSecond and MaxBytesPerSecond limit the send rate with
+	// a token bucket that allows bursts of one second. When zero the rate
+	// is not limited.
+	MaxPacketsPerSecond float64
+	MaxBytesPerSecond   float64
+	// RateLimitPolicy decides whether packets over the limit are dropped
+	// or delayed, delaying blocks the client until the packet is sent.
+	RateLimitPolicy RateLimitPolicy
+
+	// StatsInterval is how often the client reports its own ClientStats
+	// through itself as metrics of the "cdclient" plugin. When zero
+	// nothing is reported. The client is flushed after each report, so
+	// partly filled packets are also sent at least this often.
+	StatsInterval time.Duration
+	// StatsHost is the host of reported stats, when empty Defaults.Host
+	// or os.Hostname() is used.
+	StatsHost string
+
+	// Defaults fill in the Host and Interval of metrics without them.
+	Defaults MetricDefaults
+}
+
+func (opts *UDPClientOptions) hasSockopts() bool {
+	return opts.TTL != 0 || opts.Interface != "" || opts.TOS != 0 || opts.SendBufferSize != 0 ||
+		opts.DisableMulticastLoopback
 }
 
 // A udp client that buffers metrics to write complete udp packets.
+// Packets are sent over a Transport, which is usually a udp socket.
 // The client is safe to use from multiple goroutines concurrently.
 type UDPClient struct {
 	lock      sync.Mutex
-	conn      *net.UDPConn
+	network   string
+	conn      Transport
 	packet    Packet
-	tmpValues []float64
+	batch     *packetBatch
+	limiter   *rateLimiter
+	creds     CredentialProvider
+	username  string
+	password  string
+	stats     ClientStats
+	statsStop chan struct{}
+	// redialStop stops redialing every ResolveInterval.
+	redialStop chan struct{}
+	done       chan struct{}
+	defaults   MetricDefaults
+	tmpValues  []float64
+	tmpMetric  Metric
 }
 
 // Dial connects to the collectd server at address. "address" must be a network
 // address accepted by net.Dial().
 func DialUDP(address string, opts UDPClientOptions) (*UDPClient, error) {
-	c := &UDPClient{}
+	return dial("udp", address, opts)
+}
+
+// DialUnixgram connects to a collectd server listening on the unix
+// datagram socket at path.
+func DialUnixgram(path string, opts UDPClientOptions) (*UDPClient, error) {
+	return dial("unixgram", path, opts)
+}
+
+func newUDPClient(network string) *UDPClient {
+	return &UDPClient{
+		network: network,
+		done:    make(chan struct{}),
+	}
+}
+
+func dial(network, address string, opts UDPClientOptions) (*UDPClient, error) {
+	c := newUDPClient(network)
 	err := c.Reconnect(address, opts)
 	if err != nil {
 		return nil, err
@@ -45,7 +149,57 @@ func DialUDP(address string, opts UDPClientOptions) (*UDPClient, error) {
 	return c, nil
 }
 
+// NewUDPClient creates a client that sends packets over an already
+// open transport, for example a socket passed in by systemd socket
+// activation or a MemoryTransport in tests.
+func NewUDPClient(t Transport, opts UDPClientOptions) (*UDPClient, error) {
+	c := newUDPClient("udp")
+	err := c.SetTransport(t, opts)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reconnect flushes buffered metrics and dials address with the network
+// the client was created with, the previous transport is closed.
+// Clients created with NewUDPClient dial udp.
 func (c *UDPClient) Reconnect(address string, opts UDPClientOptions) error {
+	return c.reconnect(func() (Transport, error) {
+		return dialTransport(c.network, address, &opts)
+	}, opts)
+}
+
+func dialTransport(network, address string, opts *UDPClientOptions) (Transport, error) {
+	var err error
+	d := net.Dialer{
+		Control: func(network, address string, c syscall.RawConn) error {
+			return setSockopts(network, address, c, opts)
+		},
+	}
+	if opts.LocalAddress != "" {
+		switch network {
+		case "unixgram":
+			d.LocalAddr, err = net.ResolveUnixAddr(network, opts.LocalAddress)
+		default:
+			d.LocalAddr, err = net.ResolveUDPAddr(network, opts.LocalAddress)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d.Dial(network, address)
+}
+
+// SetTransport flushes buffered metrics and replaces the transport
+// and options of the client, the previous transport is closed.
+func (c *UDPClient) SetTransport(t Transport, opts UDPClientOptions) error {
+	return c.reconnect(func() (Transport, error) {
+		return t, nil
+	}, opts)
+}
+
+func (c *UDPClient) reconnect(dial func() (Transport, error), opts UDPClientOptions) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
@@ -54,44 +208,128 @@ func (c *UDPClient) Reconnect(address string, opts UDPClientOptions) error {
 		_ = c.flush()
 	}
 
-	var err error
-	var packet Packet
-
 	if opts.BufferSize == 0 {
 		opts.BufferSize = DefaultBufferSize
 	}
-
-	switch opts.Mode {
-	case UDPPlainText:
-		packet = NewPlainTextPacketSize(opts.BufferSize)
-	case UDPSign:
-		packet, err = NewSignedPacketSize(opts.Username, opts.Password, opts.BufferSize)
-		if err != nil {
-			return err
-		}
-	case UDPEncrypt:
-		packet, err = NewEncryptedPacketSize(opts.Username, opts.Password, opts.BufferSize)
+	if opts.BufferSize < MinimumBufferSize || opts.BufferSize > MaxBufferSize {
+		return fmt.Errorf("buffer size must be %d-%d bytes", MinimumBufferSize, MaxBufferSize)
+	}
+	if opts.Credentials != nil {
+		username, password, err := opts.Credentials.Credentials()
 		if err != nil {
 			return err
 		}
-	default:
-		return errors.New("unsupport client mode")
+		opts.Username, opts.Password = username, password
 	}
 
-	conn, err := net.Dial("udp", address)
+	packet, err := newPacket(&opts)
 	if err != nil {
 		return err
 	}
 
-	if c.conn != nil {
+	conn, err := dial()
+	if err != nil {
+		return err
+	}
+
+	if opts.PathMTU {
+		if size, ok := pathMTUBufferSize(conn); ok && size != opts.BufferSize {
+			opts.BufferSize = size
+			packet, err = newPacket(&opts)
+			if err != nil {
+				if conn != c.conn {
+					_ = conn.Close()
+				}
+				return err
+			}
+		}
+	}
+
+	if c.conn != nil && c.conn != conn {
 		_ = c.conn.Close()
 	}
 
-	c.conn = conn.(*net.UDPConn)
+	c.conn = conn
 	c.packet = packet
+	c.limiter = newRateLimiter(&opts)
+	c.creds = opts.Credentials
+	c.username, c.password = opts.Username, opts.Password
+	c.defaults = opts.Defaults
+	c.batch = nil
+	if opts.BatchSize > 1 {
+		c.batch = newPacketBatch(opts.BatchSize, opts.BufferSize)
+	}
+	c.stopStats()
+	c.statsStop = startStats(c, &opts)
+	c.stopRedial()
+	if opts.ResolveInterval > 0 {
+		c.redialStop = make(chan struct{})
+		go c.redial(dial, opts.ResolveInterval, c.redialStop)
+	}
 	return nil
 }
 
+func newPacket(opts *UDPClientOptions) (Packet, error) {
+	switch opts.Mode {
+	case UDPPlainText:
+		return NewPlainTextPacketSize(opts.BufferSize), nil
+	case UDPSign:
+		return NewSignedPacketSize(opts.Username, opts.Password, opts.BufferSize)
+	case UDPEncrypt:
+		return NewEncryptedPacketSize(opts.Username, opts.Password, opts.BufferSize)
+	default:
+		return nil, errors.New("unsupport client mode")
+	}
+}
+
+func (c *UDPClient) stopStats() {
+	if c.statsStop != nil {
+		close(c.statsStop)
+		c.statsStop = nil
+	}
+}
+
+func (c *UDPClient) stopRedial() {
+	if c.redialStop != nil {
+		close(c.redialStop)
+		c.redialStop = nil
+	}
+}
+
+// redial dials the server again every interval and swaps in the new
+// connection, the current connection is kept if dialing fails.
+// Dialing happens without the lock so adding metrics is not blocked.
+func (c *UDPClient) redial(dial func() (Transport, error), interval time.Duration, stop chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			conn, err := dial()
+			if err != nil {
+				continue
+			}
+			c.lock.Lock()
+			select {
+			case <-stop:
+				// The client was closed or reconnected meanwhile.
+				c.lock.Unlock()
+				_ = conn.Close()
+				return
+			default:
+			}
+			old := c.conn
+			c.conn = conn
+			c.lock.Unlock()
+			if old != conn {
+				_ = old.Close()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
 func (c *UDPClient) AddValues(m *Metric, t time.Time, values ...float64) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -111,9 +349,11 @@ func (c *UDPClient) AddValueList(v ValueList) error {
 }
 
 func (c *UDPClient) addValueList(v ValueList) error {
+	v.Metric = c.defaults.apply(v.Metric, &c.tmpMetric)
 	err := c.packet.AddValueList(v)
 	if errors.Is(err, ErrPacketFull) {
-		err = c.flush()
+		c.stats.FullFlushes++
+		err = c.sendPacket()
 		if err != nil {
 			c.conn.Close()
 			return err
@@ -123,12 +363,52 @@ func (c *UDPClient) addValueList(v ValueList) error {
 	return err
 }
 
-func (c *UDPClient) flush() error {
-	buf := c.packet.Finalize()
+// AddNotification sends a notification with the buffered metrics.
+func (c *UDPClient) AddNotification(n *Notification) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	p, ok := c.packet.(NotificationSink)
+	if !ok {
+		return errors.New("packet does not support notifications")
+	}
+	err := p.AddNotification(n)
+	if errors.Is(err, ErrPacketFull) {
+		c.stats.FullFlushes++
+		err = c.sendPacket()
+		if err != nil {
+			c.conn.Close()
+			return err
+		}
+		return p.AddNotification(n)
+	}
+	return err
+}
+
+// sendPacket finalizes the current packet and either writes
+// it or adds it to the batch, sending the batch once full.
+func (c *UDPClient) sendPacket() error {
+	buf, err := finalize(c.packet)
+	if err != nil {
+		c.stats.PacketsDropped++
+		c.packet.Reset()
+		return err
+	}
 	if len(buf) == 0 {
 		return nil
 	}
-	_, err := c.conn.Write(buf)
+	if c.limiter != nil && !c.limiter.allow(len(buf), &c.stats) {
+		c.packet.Reset()
+		return nil
+	}
+	if c.batch != nil {
+		c.batch.add(buf)
+		if c.batch.full() {
+			err = c.batch.send(c.conn, &c.stats)
+		}
+	} else {
+		_, err = c.conn.Write(buf)
+		c.stats.countWrite(len(buf), err)
+	}
 	// unconditionally reset the packet state,
 	// it is the same as dropping the packet
 	// on the wire.
@@ -136,12 +416,137 @@ func (c *UDPClient) flush() error {
 	return err
 }
 
+func (c *UDPClient) flush() error {
+	err := c.sendPacket()
+	if c.batch != nil {
+		if berr := c.batch.send(c.conn, &c.stats); err == nil {
+			err = berr
+		}
+	}
+	return err
+}
+
 func (c *UDPClient) Flush() error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	return c.flush()
 }
 
+// SendTemplate flushes any buffered metrics, then sends the template
+// signed or encrypted as configured.
+func (c *UDPClient) SendTemplate(t *PacketTemplate) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.sendPacket(); err != nil {
+		return err
+	}
+	if err := t.Encode(c.packet); err != nil {
+		return err
+	}
+	return c.flush()
+}
+
+type credentialSetter interface {
+	SetCredentials(username, password string) error
+}
+
+// SetCredentials flushes metrics buffered under the old credentials, then
+// switches to the new ones. It has no effect in plain text mode.
+func (c *UDPClient) SetCredentials(username, password string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	p, ok := c.packet.(credentialSetter)
+	if !ok {
+		return nil
+	}
+	// Dropped packets are counted in the stats, the
+	// credentials must change regardless.
+	_ = c.flush()
+	if err := p.SetCredentials(username, password); err != nil {
+		return err
+	}
+	c.username, c.password = username, password
+	return nil
+}
+
+// RefreshCredentials resolves the credentials again from the
+// CredentialProvider the client was dialed with, if any, and switches
+// to them as SetCredentials does.
+func (c *UDPClient) RefreshCredentials() error {
+	c.lock.Lock()
+	creds := c.creds
+	c.lock.Unlock()
+	if creds == nil {
+		return nil
+	}
+	username, password, err := creds.Credentials()
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	unchanged := username == c.username && password == c.password
+	c.lock.Unlock()
+	if unchanged {
+		return nil
+	}
+	return c.SetCredentials(username, password)
+}
+
+// WatchAuthFile checks the auth file for changes every interval and
+// switches the client to the password of username when it differs
+// from the one in use. Watching stops when the client is closed.
+func (c *UDPClient) WatchAuthFile(a *AuthFile, username string, interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				// The file may have been refreshed elsewhere, so
+				// compare with the credentials in use either way.
+				_, _ = a.Refresh()
+				p, ok := a.Password(username)
+				if !ok {
+					continue
+				}
+				c.lock.Lock()
+				unchanged := username == c.username && p == c.password
+				c.lock.Unlock()
+				if !unchanged {
+					_ = c.SetCredentials(username, p)
+				}
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stats returns a snapshot of the client statistics.
+func (c *UDPClient) Stats() ClientStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.stats
+}
+
+// Close sends any full packets waiting in the batch and closes the
+// transport, metrics not yet in a full packet must be sent with Flush.
 func (c *UDPClient) Close() error {
-	return c.conn.Close()
+	var err error
+	c.lock.Lock()
+	c.stopStats()
+	c.stopRedial()
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+		if c.batch != nil {
+			err = c.batch.send(c.conn, &c.stats)
+		}
+	}
+	c.lock.Unlock()
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
@@ -0,0 +1,281 @@
+package cdclient
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testMetric() *Metric {
+	return &Metric{
+		Host:     "example.com",
+		Plugin:   "golang",
+		Type:     "gauge",
+		DSTypes:  []DSType{GAUGE},
+		Interval: 10 * time.Second,
+	}
+}
+
+func TestUDPClientMemoryTransport(t *testing.T) {
+	tr := &MemoryTransport{}
+	c, err := NewUDPClient(tr, UDPClientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := testMetric()
+	now := time.Unix(1426076671, 123000000)
+	if err := c.AddValues(m, now, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := NewPlainTextPacket()
+	_ = want.AddValues(m, now, 1)
+
+	got := tr.Packets()
+	if len(got) != 1 {
+		t.Fatalf("got %d packets, want 1", len(got))
+	}
+	if !reflect.DeepEqual(got[0], want.Finalize()) {
+		t.Fatalf("got %v, want %v", got[0], want.Finalize())
+	}
+}
+
+func TestUDPClientDefaults(t *testing.T) {
+	tr := &MemoryTransport{}
+	c, err := NewUDPClient(tr, UDPClientOptions{
+		Defaults: MetricDefaults{Host: "example.com", Interval: 10 * time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := testMetric()
+	now := time.Unix(1426076671, 123000000)
+	want := NewPlainTextPacket()
+	_ = want.AddValues(m, now, 1)
+
+	m.Host = ""
+	m.Interval = 0
+	if err := c.AddValues(m, now, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	got := tr.Packets()
+	if len(got) != 1 || !reflect.DeepEqual(got[0], want.Finalize()) {
+		t.Fatalf("got %v, want %v", got, want.Finalize())
+	}
+}
+
+func TestUDPClientUnixgram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collectd.sock")
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+
+	c, err := DialUnixgram(path, UDPClientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.AddValues(testMetric(), time.Now(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	_ = l.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, DefaultBufferSize)
+	n, err := l.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Fatal("empty packet")
+	}
+}
+
+func TestUDPClientBatch(t *testing.T) {
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c, err := DialUDP(l.LocalAddr().String(), UDPClientOptions{
+		BufferSize: MinimumBufferSize,
+		BatchSize:  4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	m := testMetric()
+	now := time.Unix(1426076671, 0)
+	for i := 0; i < 100; i++ {
+		if err := c.AddValues(m, now.Add(time.Duration(i)*time.Second), float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = l.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, MinimumBufferSize)
+	total := 0
+	for total < 100 {
+		n, err := l.Read(buf)
+		if err != nil {
+			t.Fatalf("got %d of 100 value lists: %v", total, err)
+		}
+		total += countValueLists(buf[:n])
+	}
+}
+
+func TestUDPClientCloseSendsBatch(t *testing.T) {
+	tr := &MemoryTransport{}
+	c, err := NewUDPClient(tr, UDPClientOptions{
+		BufferSize: MinimumBufferSize,
+		BatchSize:  4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := testMetric()
+	now := time.Unix(1426076671, 0)
+	for i := 0; c.Stats().FullFlushes == 0; i++ {
+		if err := c.AddValues(m, now.Add(time.Duration(i)*time.Second), float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tr.Packets()) != 0 {
+		t.Fatal("the batch was sent before it was full")
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(tr.Packets()); n != 1 {
+		t.Fatalf("got %d packets after closing, want 1", n)
+	}
+}
+
+// countValueLists counts the values parts of a plain text packet.
+func countValueLists(p []byte) int {
+	n := 0
+	for off := 0; off+4 <= len(p); {
+		typ := int(p[off])<<8 | int(p[off+1])
+		size := int(p[off+2])<<8 | int(p[off+3])
+		if typ == typeValues {
+			n++
+		}
+		off += size
+	}
+	return n
+}
+
+func benchmarkUDPClient(bench *testing.B, batchSize int) {
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		bench.Fatal(err)
+	}
+	defer l.Close()
+	c, err := DialUDP(l.LocalAddr().String(), UDPClientOptions{
+		Mode:      UDPEncrypt,
+		Username:  "username",
+		Password:  "password",
+		BatchSize: batchSize,
+	})
+	if err != nil {
+		bench.Fatal(err)
+	}
+	defer c.Close()
+	v := ValueList{
+		Metric: &Metric{
+			Host:     "example.com",
+			Plugin:   "golang",
+			Type:     "foobar",
+			DSTypes:  []DSType{DERIVE, GAUGE},
+			Interval: 10 * time.Second,
+		},
+		Values: []float64{1, 2},
+	}
+	bench.ReportAllocs()
+	bench.ResetTimer()
+	for n := 0; n < bench.N; n++ {
+		// Changing the time forces a time part per value list,
+		// so packets fill at a realistic rate.
+		v.Time = time.Unix(1426076671, int64(n))
+		if err := c.AddValueList(v); err != nil {
+			bench.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUDPClientSend(bench *testing.B) {
+	benchmarkUDPClient(bench, 0)
+}
+
+func BenchmarkUDPClientSendBatch(bench *testing.B) {
+	benchmarkUDPClient(bench, 32)
+}
+
+func TestUDPClientResolveInterval(t *testing.T) {
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := DialUDP(l.LocalAddr().String(), UDPClientOptions{ResolveInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.lock.Lock()
+	first := c.conn
+	c.lock.Unlock()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_ = c.AddValues(testMetric(), time.Now(), 1)
+		if err := c.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		c.lock.Lock()
+		redialed := c.conn != first
+		c.lock.Unlock()
+		if redialed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client did not dial again")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUDPClientNotification(t *testing.T) {
+	tr := &MemoryTransport{}
+	c, err := NewUDPClient(tr, UDPClientOptions{Mode: UDPSign, Username: "username", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &Notification{Severity: Okay, Time: time.Unix(1426076671, 0), Host: "example.com", Message: "hi"}
+	if err := c.AddNotification(n); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	got := tr.Packets()
+	if len(got) != 1 || !checkSignature(got[0], "username", "password") {
+		t.Fatalf("got %v", got)
+	}
+}